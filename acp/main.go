@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/sourcenetwork/defradb/acp/identity" // DefraDB identities
+	"github.com/sourcenetwork/defradb/client"       // DefraDB client
+	"github.com/sourcenetwork/defradb/crypto"       // Key types for identities
+	"github.com/sourcenetwork/defradb/node"         // DefraDB node
+	"github.com/sourcenetwork/immutable"            // Optional values used by the identity context
+)
+
+// This example demonstrates how to use DefraDB's document access control (ACP)
+// with an embedded node.
+//
+// The process is as follows:
+// 1. Generate two identities: Alice, who will own the data, and Bob, who will
+//    try to read it.
+// 2. Register a policy with the node. A policy describes the resources we want
+//    to protect, the relations an actor can have with them (e.g. owner, reader),
+//    and which relations grant which permissions.
+// 3. Add a collection schema that is bound to a resource in that policy.
+// 4. Create a document as Alice. Because the collection is policy-bound, Alice
+//    becomes the owner of the document.
+// 5. Read the document anonymously, as Bob, and as Alice to show that only the
+//    owner can see it.
+// 6. Grant Bob the reader relation and show that the read now succeeds for Bob.
+//
+// Prerequisites:
+// - None. The example runs entirely in memory.
+
+// policy is a DefraDB Policy Interface (DPI) definition, written in YAML.
+//
+// In this policy:
+//   - `actor` names the type used for identities interacting with the resources.
+//   - `resources.notes` is the resource our collection is bound to.
+//   - `relations` defines the relationships an actor can have with a document.
+//     The creator of a document is always given the `owner` relation.
+//   - `permissions` map operations to relations. Reading a note requires being
+//     either its `owner` or a `reader`. Only the owner may update or delete it.
+const policy = `
+name: notes policy
+description: A policy that lets the owner of a note share read access with others.
+
+actor:
+  name: actor
+
+resources:
+  notes:
+    permissions:
+      read:
+        expr: owner + reader
+      update:
+        expr: owner
+      delete:
+        expr: owner
+
+    relations:
+      owner:
+        types:
+          - actor
+      reader:
+        types:
+          - actor
+`
+
+func main() {
+	ctx := context.Background()
+
+	// --- Step 1: Set up DefraDB and generate identities ---
+	log.Println("================================================================================")
+	log.Println("Set up DefraDB and generate identities")
+	log.Println("================================================================================")
+
+	// For this example, we'll use an in-memory instance of DefraDB with the
+	// default local ACP system. We disable the P2P and API servers as we are
+	// using DefraDB embedded in our application.
+	log.Println("Setting up DefraDB...")
+	db, err := node.New(ctx, node.WithBadgerInMemory(true), node.WithDisableAPI(true), node.WithDisableP2P(true))
+	if err != nil {
+		log.Fatalf("Failed to create DefraDB node: %v", err)
+	}
+	defer db.Close(ctx)
+	err = db.Start(ctx)
+	if err != nil {
+		log.Fatalf("Failed to start DefraDB node: %v", err)
+	}
+
+	// An identity is a keypair. Its public key is turned into a DID, which is
+	// how DefraDB refers to actors in relationships.
+	log.Println("Generating identities for Alice and Bob...")
+	alice, err := identity.Generate(crypto.KeyTypeSecp256k1)
+	if err != nil {
+		log.Fatalf("Failed to generate identity for Alice: %v", err)
+	}
+	bob, err := identity.Generate(crypto.KeyTypeSecp256k1)
+	if err != nil {
+		log.Fatalf("Failed to generate identity for Bob: %v", err)
+	}
+	log.Printf(" - Alice: %s\n", alice.DID())
+	log.Printf(" - Bob:   %s\n", bob.DID())
+
+	// Every request made on behalf of an identity carries it in the context.
+	// Requests made with a plain context are anonymous.
+	aliceCtx := identity.WithContext(ctx, immutable.Some[identity.Identity](alice))
+	bobCtx := identity.WithContext(ctx, immutable.Some[identity.Identity](bob))
+
+	// --- Step 2: Register the policy and a policy-bound schema ---
+	log.Println("================================================================================")
+	log.Println("Register the policy and a policy-bound schema")
+	log.Println("================================================================================")
+
+	// Policies are added by an identity, which becomes the policy's creator.
+	log.Println("Adding the notes policy as Alice...")
+	policyResult, err := db.DB.AddDACPolicy(aliceCtx, policy)
+	if err != nil {
+		log.Fatalf("Failed to add policy: %v", err)
+	}
+	log.Printf("Policy ID: %s\n", policyResult.PolicyID)
+
+	// The `@policy` directive binds the collection to a resource in the policy
+	// we just added. From now on, every document in this collection is
+	// protected by the permissions defined for the `notes` resource.
+	log.Println("Adding 'Note' collection schema to DefraDB...")
+	_, err = db.DB.AddSchema(ctx, fmt.Sprintf(`type Note @policy(id: "%s", resource: "notes") {
+		title: String
+		body: String
+	}`, policyResult.PolicyID))
+	if err != nil {
+		log.Fatalf("Failed to add schema: %v", err)
+	}
+
+	// --- Step 3: Create a document as Alice ---
+	log.Println("================================================================================")
+	log.Println("Create a document owned by Alice")
+	log.Println("================================================================================")
+	createResult := db.DB.ExecRequest(
+		aliceCtx,
+		`mutation CreateNote($input: [NoteMutationInputArg!]!) {
+			create_Note(input: $input) {
+				_docID
+			}
+		}`,
+		client.WithVariables(map[string]any{
+			"input": map[string]any{
+				"title": "Secret recipe",
+				"body":  "Two parts flour, one part water, a pinch of salt.",
+			},
+		}),
+	)
+	if len(createResult.GQL.Errors) > 0 {
+		for _, gqlErr := range createResult.GQL.Errors {
+			log.Printf("GraphQL error on create: %v\n", gqlErr)
+		}
+		log.Fatalf("Failed to create document in DefraDB.")
+	}
+	data, _ := createResult.GQL.Data.(map[string]any)
+	created, _ := data["create_Note"].([]map[string]any)
+	if len(created) == 0 {
+		log.Fatalf("Unexpected create result from DefraDB: %v", createResult.GQL.Data)
+	}
+	docID, ok := created[0]["_docID"].(string)
+	if !ok {
+		log.Fatalf("Unexpected create result from DefraDB: %v", createResult.GQL.Data)
+	}
+	log.Printf("Created note with document ID %s\n", docID)
+
+	// --- Step 4: Read the document as different identities ---
+	log.Println("================================================================================")
+	log.Println("Reading the document before sharing it")
+	log.Println("================================================================================")
+	readNotes(ctx, db, "Anonymous")
+	readNotes(bobCtx, db, "Bob")
+	readNotes(aliceCtx, db, "Alice")
+
+	// --- Step 5: Share the document with Bob and read it again ---
+	// Only the owner can manage relationships on a document. Here Alice gives
+	// Bob the `reader` relation, which the policy says grants `read`.
+	log.Println("================================================================================")
+	log.Println("Reading the document after sharing it with Bob")
+	log.Println("================================================================================")
+	log.Println("Alice grants Bob the 'reader' relation on the note...")
+	relationshipResult, err := db.DB.AddDACActorRelationship(aliceCtx, "Note", docID, "reader", bob.DID())
+	if err != nil {
+		log.Fatalf("Failed to add reader relationship: %v", err)
+	}
+	if relationshipResult.ExistedAlready {
+		log.Println("Bob was already a reader of the note.")
+	}
+	readNotes(ctx, db, "Anonymous")
+	readNotes(bobCtx, db, "Bob")
+
+	/* Output (identities and IDs differ on each run):
+	2024/08/02 14:30:12 ================================================================================
+	2024/08/02 14:30:12 Reading the document before sharing it
+	2024/08/02 14:30:12 ================================================================================
+	2024/08/02 14:30:12 Anonymous sees 0 note(s): []
+	2024/08/02 14:30:12 Bob sees 0 note(s): []
+	2024/08/02 14:30:12 Alice sees 1 note(s): [{"body":"Two parts flour, ...","title":"Secret recipe"}]
+	...
+	2024/08/02 14:30:12 Alice grants Bob the 'reader' relation on the note...
+	2024/08/02 14:30:12 Anonymous sees 0 note(s): []
+	2024/08/02 14:30:12 Bob sees 1 note(s): [{"body":"Two parts flour, ...","title":"Secret recipe"}]
+	*/
+}
+
+// readNotes queries all notes using the identity carried by ctx (if any) and
+// logs what that identity is allowed to see.
+//
+// Note that an unauthorized read is not an error: DefraDB simply filters out
+// the documents the requesting identity has no `read` permission for.
+func readNotes(ctx context.Context, db *node.Node, who string) {
+	result := db.DB.ExecRequest(ctx, `query {
+		Note {
+			title
+			body
+		}
+	}`)
+	if len(result.GQL.Errors) > 0 {
+		for _, gqlErr := range result.GQL.Errors {
+			log.Printf("GraphQL error on query: %v\n", gqlErr)
+		}
+		log.Fatalf("Failed to query documents from DefraDB.")
+	}
+
+	data, _ := result.GQL.Data.(map[string]any)
+	notes, ok := data["Note"].([]map[string]any)
+	if !ok {
+		notes = []map[string]any{}
+	}
+	out, err := json.Marshal(notes)
+	if err != nil {
+		log.Fatalf("Failed to marshal query result: %v", err)
+	}
+	log.Printf("%s sees %d note(s): %s\n", who, len(notes), out)
+}
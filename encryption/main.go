@@ -0,0 +1,338 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/sourcenetwork/defradb/client"               // DefraDB client
+	netConfig "github.com/sourcenetwork/defradb/net/config" // DefraDB P2P networking options
+	"github.com/sourcenetwork/defradb/node"                 // DefraDB node
+)
+
+// This example demonstrates DefraDB's built-in document encryption with an
+// embedded node.
+//
+// The process is as follows:
+// 1. Start two nodes, A and B, and add a collection schema for patient records
+//    to both. Node B is a replicator of node A, so it receives every change.
+// 2. On node A, create one document that is fully encrypted, and one where
+//    only the sensitive field is encrypted.
+// 3. Query the documents on node A. It holds the encryption keys, so it
+//    transparently decrypts them and returns plaintext.
+// 4. Inspect the raw commits of each document. Commits are what is stored and
+//    shared with other peers, and they contain ciphertext for every encrypted
+//    field.
+// 5. Query the same documents on node B. It received the commits but not the
+//    keys, so the encrypted fields can't be read there.
+//
+// Encryption is requested per mutation using the `encrypt` and `encryptFields`
+// arguments. The keys are generated and held by the node itself. In DefraDB
+// v0.19, there is no ExecRequest option to pass a key with a request: whether a
+// read returns plaintext depends on whether the node holds the key. Other
+// peers can only decrypt the data after obtaining the keys from this node over
+// P2P.
+//
+// Prerequisites:
+// - None. The example runs entirely in memory.
+
+const (
+	// previewLen is the number of characters shown when printing commit deltas.
+	previewLen = 60
+
+	// syncTimeout bounds how long we wait for the commits to reach node B.
+	syncTimeout = 10 * time.Second
+
+	// pollInterval is how often node B is queried while waiting for commits.
+	pollInterval = 100 * time.Millisecond
+)
+
+func main() {
+	ctx := context.Background()
+
+	// --- Step 1: Set up DefraDB ---
+	log.Println("================================================================================")
+	log.Println("Set up two DefraDB nodes")
+	log.Println("================================================================================")
+
+	// For this example, we'll use two in-memory instances of DefraDB. Node A
+	// writes the documents, and node B receives them over P2P. We disable the
+	// API servers as we are using DefraDB embedded in our application.
+	db := startNode(ctx, "A")
+	defer db.Close(ctx)
+	nodeB := startNode(ctx, "B")
+	defer nodeB.Close(ctx)
+
+	// Note that nothing in the schema marks a field as encrypted. Encryption
+	// is a property of each write, not of the collection.
+	log.Println("Adding 'Patient' collection schema to both nodes...")
+	for _, n := range []*node.Node{db, nodeB} {
+		_, err := n.DB.AddSchema(ctx, `type Patient {
+			name: String
+			diagnosis: String
+		}`)
+		if err != nil {
+			log.Fatalf("Failed to add schema: %v", err)
+		}
+	}
+
+	// Node A pushes every change to the collection to node B. The commits
+	// are sent as they are stored, encrypted fields included, but the keys
+	// stay on node A.
+	log.Println("Setting node B as a replicator of node A...")
+	err := db.Peer.SetReplicator(ctx, nodeB.Peer.PeerInfo(), "Patient")
+	if err != nil {
+		log.Fatalf("Failed to set replicator: %v", err)
+	}
+
+	// --- Step 2: Write encrypted documents ---
+	log.Println("================================================================================")
+	log.Println("Write encrypted documents")
+	log.Println("================================================================================")
+
+	// `encrypt: true` encrypts every field of the document with a single
+	// document key.
+	log.Println("Creating a fully encrypted document...")
+	fullDocID := createPatient(ctx, db, "encrypt: true", map[string]any{
+		"name":      "Ada",
+		"diagnosis": "Seasonal allergies",
+	})
+	log.Printf("Created document %s\n", fullDocID)
+
+	// `encryptFields` encrypts only the listed fields, each with its own key.
+	// The remaining fields are stored in plaintext.
+	log.Println("Creating a document with only 'diagnosis' encrypted...")
+	fieldDocID := createPatient(ctx, db, "encryptFields: [diagnosis]", map[string]any{
+		"name":      "Grace",
+		"diagnosis": "Sprained ankle",
+	})
+	log.Printf("Created document %s\n", fieldDocID)
+
+	// --- Step 3: Read the documents ---
+	log.Println("================================================================================")
+	log.Println("Reading the documents on node A, which holds the keys")
+	log.Println("================================================================================")
+	printPatients(ctx, db, fullDocID, fieldDocID)
+
+	// --- Step 4: Inspect what is actually stored ---
+	// Every change to a document is recorded as a commit. Field commits carry
+	// the new value in their `delta`, which is what gets persisted and
+	// replicated. For encrypted fields, the delta is ciphertext.
+	log.Println("================================================================================")
+	log.Println("Inspecting the stored commits")
+	log.Println("================================================================================")
+	printCommits(ctx, db, "fully encrypted", fullDocID)
+	printCommits(ctx, db, "field encrypted", fieldDocID)
+
+	// --- Step 5: Read the documents without the keys ---
+	// Node B stores the same commits, but has no key to decrypt them. It
+	// can't read the encrypted fields: they come back as null, or the
+	// document doesn't show up at all until the keys are available. The
+	// unencrypted `name` of the second document is readable everywhere.
+	log.Println("================================================================================")
+	log.Println("Reading the documents on node B, which doesn't hold the keys")
+	log.Println("================================================================================")
+	waitForCommits(ctx, nodeB, fullDocID)
+	waitForCommits(ctx, nodeB, fieldDocID)
+	printPatients(ctx, nodeB, fullDocID, fieldDocID)
+
+	/* Output (document IDs and ciphertext differ on each run):
+	2024/08/02 14:30:12 ================================================================================
+	2024/08/02 14:30:12 Reading the documents on node A, which holds the keys
+	2024/08/02 14:30:12 ================================================================================
+	2024/08/02 14:30:12  - bae-...: name="Ada" diagnosis="Seasonal allergies"
+	2024/08/02 14:30:12  - bae-...: name="Grace" diagnosis="Sprained ankle"
+	2024/08/02 14:30:12 ================================================================================
+	2024/08/02 14:30:12 Inspecting the stored commits
+	2024/08/02 14:30:12 ================================================================================
+	2024/08/02 14:30:12 Commits of the fully encrypted document bae-...:
+	2024/08/02 14:30:12  - diagnosis: 7f2a9c...
+	2024/08/02 14:30:12  - name: 0b41e8...
+	2024/08/02 14:30:12 Commits of the field encrypted document bae-...:
+	2024/08/02 14:30:12  - diagnosis: c93d10...
+	2024/08/02 14:30:12  - name: 654772616365
+	2024/08/02 14:30:12 ================================================================================
+	2024/08/02 14:30:12 Reading the documents on node B, which doesn't hold the keys
+	2024/08/02 14:30:12 ================================================================================
+	2024/08/02 14:30:12  - bae-...: not readable without the key
+	2024/08/02 14:30:12  - bae-...: name="Grace" diagnosis=null
+	*/
+}
+
+// startNode creates and starts an in-memory DefraDB node with P2P enabled,
+// listening on a random local port.
+func startNode(ctx context.Context, name string) *node.Node {
+	log.Printf("Starting node %s...\n", name)
+	n, err := node.New(
+		ctx,
+		node.WithBadgerInMemory(true),
+		node.WithDisableAPI(true),
+		netConfig.WithListenAddresses("/ip4/127.0.0.1/tcp/0"),
+	)
+	if err != nil {
+		log.Fatalf("Failed to create DefraDB node %s: %v", name, err)
+	}
+	err = n.Start(ctx)
+	if err != nil {
+		log.Fatalf("Failed to start DefraDB node %s: %v", name, err)
+	}
+	return n
+}
+
+// printPatients logs the given Patient documents as read on node n. A field
+// the node can't decrypt is shown as null, and a document it can't read at
+// all is reported as such.
+func printPatients(ctx context.Context, n *node.Node, docIDs ...string) {
+	queryResult := n.DB.ExecRequest(
+		ctx,
+		// The docID argument of queries is a list of strings.
+		`query GetPatients($docIDs: [String!]) {
+			Patient(docID: $docIDs) {
+				_docID
+				name
+				diagnosis
+			}
+		}`,
+		client.WithVariables(map[string]any{"docIDs": docIDs}),
+	)
+	if len(queryResult.GQL.Errors) > 0 {
+		for _, gqlErr := range queryResult.GQL.Errors {
+			log.Printf("GraphQL error on query: %v\n", gqlErr)
+		}
+		log.Fatalf("Failed to query documents from DefraDB.")
+	}
+	data, _ := queryResult.GQL.Data.(map[string]any)
+	readable, ok := data["Patient"].([]map[string]any)
+	if !ok {
+		log.Fatalf("Unexpected query result from DefraDB: %v", queryResult.GQL.Data)
+	}
+	patients := map[string]map[string]any{}
+	for _, patient := range readable {
+		id, _ := patient["_docID"].(string)
+		patients[id] = patient
+	}
+	for _, docID := range docIDs {
+		patient, ok := patients[docID]
+		if !ok {
+			log.Printf(" - %s: not readable without the key\n", docID)
+			continue
+		}
+		log.Printf(" - %s: name=%s diagnosis=%s\n", docID, fieldValue(patient["name"]), fieldValue(patient["diagnosis"]))
+	}
+}
+
+// fieldValue formats a field of a query result, quoted, or null if it has no
+// value.
+func fieldValue(v any) string {
+	if v == nil {
+		return "null"
+	}
+	return fmt.Sprintf("%q", v)
+}
+
+// waitForCommits polls n until it has received commits of the document with
+// the given docID.
+//
+// Replication is asynchronous. We wait for the commits rather than for the
+// document itself, because a node without the keys may never show the
+// document.
+func waitForCommits(ctx context.Context, n *node.Node, docID string) {
+	ctx, cancel := context.WithTimeout(ctx, syncTimeout)
+	defer cancel()
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		if len(queryCommits(ctx, n, docID)) > 0 {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			log.Fatalf("The commits of document %s did not reach the node in time: %v", docID, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// createPatient creates a single Patient document and returns its document ID.
+//
+// The encryption arguments are regular mutation arguments, so they are added
+// to the `create_Patient` call next to the document input.
+func createPatient(ctx context.Context, db *node.Node, encryptArgs string, input map[string]any) string {
+	createResult := db.DB.ExecRequest(
+		ctx,
+		fmt.Sprintf(`mutation CreatePatient($input: [PatientMutationInputArg!]!) {
+			create_Patient(input: $input, %s) {
+				_docID
+			}
+		}`, encryptArgs),
+		client.WithVariables(map[string]any{
+			"input": input,
+		}),
+	)
+	if len(createResult.GQL.Errors) > 0 {
+		for _, gqlErr := range createResult.GQL.Errors {
+			log.Printf("GraphQL error on create: %v\n", gqlErr)
+		}
+		log.Fatalf("Failed to create document in DefraDB.")
+	}
+	data, _ := createResult.GQL.Data.(map[string]any)
+	created, _ := data["create_Patient"].([]map[string]any)
+	if len(created) == 0 {
+		log.Fatalf("Unexpected create result from DefraDB: %v", createResult.GQL.Data)
+	}
+	docID, ok := created[0]["_docID"].(string)
+	if !ok {
+		log.Fatalf("Unexpected create result from DefraDB: %v", createResult.GQL.Data)
+	}
+	return docID
+}
+
+// printCommits logs the field-level commits of a document together with a
+// short hex preview of their stored delta.
+func printCommits(ctx context.Context, db *node.Node, label string, docID string) {
+	log.Printf("Commits of the %s document %s:\n", label, docID)
+	for _, commit := range queryCommits(ctx, db, docID) {
+		// The composite commit of the document itself has no field name and
+		// no delta worth showing.
+		fieldName, _ := commit["fieldName"].(string)
+		if fieldName == "" || fieldName == "_C" {
+			continue
+		}
+		log.Printf(" - %s: %s\n", fieldName, preview(commit["delta"]))
+	}
+}
+
+// queryCommits returns the commits of a document stored on a node, with their
+// field name and delta.
+func queryCommits(ctx context.Context, db *node.Node, docID string) []map[string]any {
+	commitsResult := db.DB.ExecRequest(
+		ctx,
+		`query Commits($docID: ID!) {
+			commits(docID: $docID) {
+				fieldName
+				delta
+			}
+		}`,
+		client.WithVariables(map[string]any{"docID": docID}),
+	)
+	if len(commitsResult.GQL.Errors) > 0 {
+		for _, gqlErr := range commitsResult.GQL.Errors {
+			log.Printf("GraphQL error on commits query: %v\n", gqlErr)
+		}
+		log.Fatalf("Failed to query commits from DefraDB.")
+	}
+	data, _ := commitsResult.GQL.Data.(map[string]any)
+	commits, _ := data["commits"].([]map[string]any)
+	return commits
+}
+
+// preview formats a commit delta as hex and truncates it to previewLen characters.
+func preview(delta any) string {
+	s := fmt.Sprintf("%x", delta)
+	if len(s) > previewLen {
+		return s[:previewLen] + "..."
+	}
+	return s
+}
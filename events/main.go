@@ -0,0 +1,332 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/sourcenetwork/defradb/client" // DefraDB client
+	"github.com/sourcenetwork/defradb/node"   // DefraDB node
+)
+
+// This example demonstrates how to build a change data capture (CDC) feed on
+// top of DefraDB subscriptions, using an embedded node.
+//
+// The process is as follows:
+// 1. Subscribe to all changes of a collection and print every change as it
+//    happens.
+// 2. Create, update and delete a few documents and watch the changes appear
+//    in the feed.
+// 3. Simulate a disconnect by cancelling the subscription, and keep writing
+//    while the feed is offline.
+// 4. Reconnect: subscribe again and then replay everything that changed since
+//    the last change we saw, so no change is lost.
+//
+// A subscription only delivers changes that happen while it is open. To resume
+// after a disconnect, the consumer remembers a position in the stream (here, the
+// `updatedAt` timestamp of the last change it processed) and queries for
+// anything newer when it reconnects.
+//
+// That query only finds documents that still exist, so documents are never
+// deleted outright. Deleting an order marks it as `deleted` and stamps it like
+// any other change, which leaves a tombstone both the subscription and the
+// catch-up query can see.
+//
+// Prerequisites:
+// - None. The example runs entirely in memory.
+
+// settleTime is how long we give the feed to process changes before moving on
+// to the next step of the demo.
+const settleTime = 500 * time.Millisecond
+
+// change is a single entry in the change feed.
+type change struct {
+	DocID     string    `json:"_docID"`
+	Item      string    `json:"item"`
+	Quantity  int       `json:"quantity"`
+	Deleted   bool      `json:"deleted"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// changeFeed prints the changes of the Order collection exactly once, whether
+// they arrive live from a subscription or are replayed after a reconnect.
+type changeFeed struct {
+	db *node.Node
+	wg sync.WaitGroup
+
+	mu       sync.Mutex
+	lastSeen time.Time
+	seen     map[string]time.Time
+}
+
+func main() {
+	ctx := context.Background()
+
+	// --- Step 1: Set up DefraDB ---
+	log.Println("================================================================================")
+	log.Println("Set up DefraDB")
+	log.Println("================================================================================")
+
+	// For this example, we'll use an in-memory instance of DefraDB.
+	// We also disable the P2P and API servers as we are using DefraDB embedded
+	// in our application.
+	log.Println("Setting up DefraDB...")
+	db, err := node.New(ctx, node.WithBadgerInMemory(true), node.WithDisableAPI(true), node.WithDisableP2P(true))
+	if err != nil {
+		log.Fatalf("Failed to create DefraDB node: %v", err)
+	}
+	defer db.Close(ctx)
+	err = db.Start(ctx)
+	if err != nil {
+		log.Fatalf("Failed to start DefraDB node: %v", err)
+	}
+
+	// The `updatedAt` field is set by the writer on every change. It gives the
+	// feed a position it can resume from after a disconnect. The `deleted`
+	// field marks the tombstones of deleted orders.
+	log.Println("Adding 'Order' collection schema to DefraDB...")
+	_, err = db.DB.AddSchema(ctx, `type Order {
+		item: String
+		quantity: Int
+		deleted: Boolean
+		updatedAt: DateTime @index
+	}`)
+	if err != nil {
+		log.Fatalf("Failed to add schema: %v", err)
+	}
+
+	feed := &changeFeed{db: db, seen: map[string]time.Time{}}
+
+	// --- Step 2: Subscribe and write ---
+	log.Println("================================================================================")
+	log.Println("Subscribing to changes")
+	log.Println("================================================================================")
+	subCtx, disconnect := context.WithCancel(ctx)
+	feed.connect(subCtx)
+
+	apples := writeOrder(ctx, db, "apples", 3)
+	pears := writeOrder(ctx, db, "pears", 5)
+	updateOrder(ctx, db, pears, map[string]any{"quantity": 6})
+	time.Sleep(settleTime)
+
+	// --- Step 3: Disconnect and keep writing ---
+	log.Println("================================================================================")
+	log.Println("Disconnecting the feed and writing while it is offline")
+	log.Println("================================================================================")
+	disconnect()
+	feed.wg.Wait()
+
+	writeOrder(ctx, db, "plums", 7)
+	updateOrder(ctx, db, apples, map[string]any{"quantity": 4})
+	deleteOrder(ctx, db, pears)
+
+	// --- Step 4: Reconnect and resume ---
+	log.Println("================================================================================")
+	log.Println("Reconnecting and resuming the feed")
+	log.Println("================================================================================")
+	feed.resume(ctx)
+
+	writeOrder(ctx, db, "cherries", 9)
+	time.Sleep(settleTime)
+
+	/* Output (document IDs and timestamps differ on each run):
+	2024/08/02 14:30:12 [live]   bae-...: apples x3 at 2024-08-02T14:30:12.101Z
+	2024/08/02 14:30:12 [live]   bae-...: pears x5 at 2024-08-02T14:30:12.112Z
+	2024/08/02 14:30:12 [live]   bae-...: pears x6 at 2024-08-02T14:30:12.118Z
+	...
+	2024/08/02 14:30:13 Subscription closed.
+	...
+	2024/08/02 14:30:13 Resuming from 2024-08-02T14:30:12.118Z...
+	2024/08/02 14:30:13 [replay] bae-...: plums x7 at 2024-08-02T14:30:13.120Z
+	2024/08/02 14:30:13 [replay] bae-...: apples x4 at 2024-08-02T14:30:13.131Z
+	2024/08/02 14:30:13 [replay] bae-...: pears deleted at 2024-08-02T14:30:13.137Z
+	2024/08/02 14:30:13 [live]   bae-...: cherries x9 at 2024-08-02T14:30:13.142Z
+	*/
+}
+
+// connect opens a subscription on the Order collection and prints its changes
+// in the background until ctx is cancelled.
+func (f *changeFeed) connect(ctx context.Context) {
+	subResult := f.db.DB.ExecRequest(ctx, `subscription {
+		Order {
+			_docID
+			item
+			quantity
+			deleted
+			updatedAt
+		}
+	}`)
+	if len(subResult.GQL.Errors) > 0 {
+		for _, gqlErr := range subResult.GQL.Errors {
+			log.Printf("GraphQL error on subscribe: %v\n", gqlErr)
+		}
+		log.Fatalf("Failed to subscribe to DefraDB.")
+	}
+
+	f.wg.Add(1)
+	go func() {
+		defer f.wg.Done()
+		// The subscription channel is closed when ctx is cancelled.
+		for event := range subResult.Subscription {
+			if len(event.Errors) > 0 {
+				for _, gqlErr := range event.Errors {
+					log.Printf("GraphQL error in subscription event: %v\n", gqlErr)
+				}
+				continue
+			}
+			for _, c := range decodeChanges(event.Data) {
+				f.emit("live", c)
+			}
+		}
+		log.Println("Subscription closed.")
+	}()
+}
+
+// resume reconnects the feed and replays every change made since the last one
+// it processed.
+//
+// We subscribe before querying for missed changes. Doing it the other way
+// around would leave a gap in which a change is neither returned by the
+// catch-up query nor delivered by the new subscription. Changes that show up
+// in both are filtered out by emit.
+func (f *changeFeed) resume(ctx context.Context) {
+	f.mu.Lock()
+	since := f.lastSeen
+	f.mu.Unlock()
+
+	f.connect(ctx)
+
+	log.Printf("Resuming from %s...\n", since.Format(time.RFC3339Nano))
+	queryResult := f.db.DB.ExecRequest(
+		ctx,
+		`query MissedChanges($since: DateTime!) {
+			Order(filter: {updatedAt: {_gt: $since}}, order: {updatedAt: ASC}) {
+				_docID
+				item
+				quantity
+				deleted
+				updatedAt
+			}
+		}`,
+		client.WithVariables(map[string]any{"since": since.Format(time.RFC3339Nano)}),
+	)
+	if len(queryResult.GQL.Errors) > 0 {
+		for _, gqlErr := range queryResult.GQL.Errors {
+			log.Printf("GraphQL error on query: %v\n", gqlErr)
+		}
+		log.Fatalf("Failed to query missed changes from DefraDB.")
+	}
+	for _, c := range decodeChanges(queryResult.GQL.Data) {
+		f.emit("replay", c)
+	}
+}
+
+// emit prints a change unless the same version of the document has already
+// been printed, and advances the resume position.
+func (f *changeFeed) emit(source string, c change) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if last, ok := f.seen[c.DocID]; ok && !c.UpdatedAt.After(last) {
+		return
+	}
+	f.seen[c.DocID] = c.UpdatedAt
+	if c.UpdatedAt.After(f.lastSeen) {
+		f.lastSeen = c.UpdatedAt
+	}
+	if c.Deleted {
+		log.Printf("%-8s %s: %s deleted at %s\n", "["+source+"]", c.DocID, c.Item, c.UpdatedAt.Format(time.RFC3339Nano))
+		return
+	}
+	log.Printf("%-8s %s: %s x%d at %s\n", "["+source+"]", c.DocID, c.Item, c.Quantity, c.UpdatedAt.Format(time.RFC3339Nano))
+}
+
+// decodeChanges converts the data of a query or subscription result into a
+// list of changes.
+func decodeChanges(data any) []change {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		log.Fatalf("Failed to marshal result data: %v", err)
+	}
+	var result struct {
+		Order []change
+	}
+	err = json.Unmarshal(raw, &result)
+	if err != nil {
+		log.Fatalf("Failed to decode changes: %v", err)
+	}
+	return result.Order
+}
+
+// writeOrder creates an Order document stamped with the current time and
+// returns its document ID.
+func writeOrder(ctx context.Context, db *node.Node, item string, quantity int) string {
+	createResult := db.DB.ExecRequest(
+		ctx,
+		`mutation CreateOrder($input: [OrderMutationInputArg!]!) {
+			create_Order(input: $input) {
+				_docID
+			}
+		}`,
+		client.WithVariables(map[string]any{
+			"input": map[string]any{
+				"item":      item,
+				"quantity":  quantity,
+				"updatedAt": time.Now().UTC().Format(time.RFC3339Nano),
+			},
+		}),
+	)
+	if len(createResult.GQL.Errors) > 0 {
+		for _, gqlErr := range createResult.GQL.Errors {
+			log.Printf("GraphQL error on create: %v\n", gqlErr)
+		}
+		log.Fatalf("Failed to create document in DefraDB.")
+	}
+	data, _ := createResult.GQL.Data.(map[string]any)
+	created, _ := data["create_Order"].([]map[string]any)
+	if len(created) == 0 {
+		log.Fatalf("Unexpected create result from DefraDB: %v", createResult.GQL.Data)
+	}
+	docID, ok := created[0]["_docID"].(string)
+	if !ok {
+		log.Fatalf("Unexpected create result from DefraDB: %v", createResult.GQL.Data)
+	}
+	return docID
+}
+
+// updateOrder changes the given fields of an Order document and stamps it with
+// the current time, so that the feed can resume past the change.
+func updateOrder(ctx context.Context, db *node.Node, docID string, fields map[string]any) {
+	fields["updatedAt"] = time.Now().UTC().Format(time.RFC3339Nano)
+	updateResult := db.DB.ExecRequest(
+		ctx,
+		// The docID argument of updates is a list of IDs.
+		`mutation UpdateOrder($docID: [ID!], $input: OrderMutationInputArg!) {
+			update_Order(docID: $docID, input: $input) {
+				_docID
+			}
+		}`,
+		client.WithVariables(map[string]any{
+			"docID": []string{docID},
+			"input": fields,
+		}),
+	)
+	if len(updateResult.GQL.Errors) > 0 {
+		for _, gqlErr := range updateResult.GQL.Errors {
+			log.Printf("GraphQL error on update: %v\n", gqlErr)
+		}
+		log.Fatalf("Failed to update document in DefraDB.")
+	}
+}
+
+// deleteOrder deletes an Order document by turning it into a tombstone.
+//
+// A document removed with `delete_Order` disappears from queries, so the
+// catch-up query of a feed that was offline at the time would never see it.
+// The tombstone is an ordinary, stamped change instead, which the feed picks
+// up live or on resume. Readers of the collection filter out the tombstones.
+func deleteOrder(ctx context.Context, db *node.Node, docID string) {
+	updateOrder(ctx, db, docID, map[string]any{"deleted": true})
+}
@@ -0,0 +1,3 @@
+module github.com/sourcenetwork/examples/internal/shared
+
+go 1.23
@@ -0,0 +1,41 @@
+package shared
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// PrintQuery writes a GraphQL request and its variables to stderr, in a form
+// that can be pasted into a GraphQL client, such as the playground of a
+// DefraDB node started with `defradb start`.
+func PrintQuery(request string, variables map[string]any) {
+	vars, err := json.Marshal(variables)
+	if err != nil {
+		log.Printf("Failed to encode the query variables: %v\n", err)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "----- GraphQL query -----\n%s\n----- variables -----\n%s\n-------------------------\n", dedent(request), vars)
+}
+
+// dedent removes the indentation the lines of a request get from being written
+// inside Go code, except for the first line, which has none.
+func dedent(request string) string {
+	lines := strings.Split(request, "\n")
+	indent := ""
+	for _, line := range lines[1:] {
+		trimmed := strings.TrimLeft(line, "\t")
+		if trimmed == "" {
+			continue
+		}
+		if lineIndent := line[:len(line)-len(trimmed)]; indent == "" || len(lineIndent) < len(indent) {
+			indent = lineIndent
+		}
+	}
+	for i, line := range lines[1:] {
+		lines[i+1] = strings.TrimPrefix(line, indent)
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,6 @@
+// Package shared holds the helpers used by more than one example, so that the
+// examples stay consistent with each other.
+//
+// Each example is a module of its own. They use this one through a `replace`
+// directive in their go.mod, so it doesn't need to be published.
+package shared
@@ -0,0 +1,11 @@
+package shared
+
+// Truncate shortens s to at most n characters, adding an ellipsis if anything
+// was cut off.
+func Truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n]) + "..."
+}
@@ -0,0 +1,24 @@
+package shared
+
+import "math"
+
+// CosineSimilarity returns the cosine similarity of two vectors, like DefraDB's
+// `_similarity`.
+//
+// Vectors of different dimensions can't be compared, and a zero vector has no
+// direction, so the similarity is 0 in both cases.
+func CosineSimilarity(a []float32, b []float32) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
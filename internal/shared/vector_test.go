@@ -0,0 +1,30 @@
+package shared
+
+import (
+	"math"
+	"testing"
+)
+
+func TestCosineSimilarity(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b []float32
+		want float64
+	}{
+		{name: "identical", a: []float32{1, 2, 3}, b: []float32{1, 2, 3}, want: 1},
+		{name: "same direction", a: []float32{1, 2, 3}, b: []float32{2, 4, 6}, want: 1},
+		{name: "orthogonal", a: []float32{1, 0}, b: []float32{0, 1}, want: 0},
+		{name: "opposite", a: []float32{1, 2, 3}, b: []float32{-1, -2, -3}, want: -1},
+		{name: "zero-length", a: []float32{}, b: []float32{}, want: 0},
+		{name: "zero vector", a: []float32{0, 0}, b: []float32{1, 1}, want: 0},
+		{name: "mismatched lengths", a: []float32{1, 2, 3}, b: []float32{1, 2}, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := CosineSimilarity(tt.a, tt.b)
+			if math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("CosineSimilarity(%v, %v) = %v, want %v", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
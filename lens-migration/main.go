@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"log"
+	"path/filepath"
+
+	"github.com/sourcenetwork/defradb/client"            // DefraDB client
+	"github.com/sourcenetwork/defradb/node"              // DefraDB node
+	"github.com/sourcenetwork/immutable"                 // Optional values used by the schema patch API
+	"github.com/sourcenetwork/lens/host-go/config/model" // Lens migration configuration
+)
+
+// This example demonstrates how to migrate documents between schema versions
+// with a Lens module, using an embedded DefraDB node.
+//
+// Lens modules are small WebAssembly programs that transform documents from one
+// schema version to another. DefraDB runs them lazily: documents written under
+// an old version are migrated when they are read under a newer one, so there
+// is no need to rewrite the whole collection.
+//
+// The process is as follows:
+// 1. Add a `User` collection with a `name` field (schema version 1).
+// 2. Create a few documents under version 1.
+// 3. Patch the schema to add a `fullName` field (schema version 2), and
+//    register a migration that renames `name` to `fullName`: a Lens module
+//    copies `name` into `fullName`, and a second one removes `name`.
+// 4. Query the same documents under version 2 and see the migrated values.
+//
+// Prerequisites:
+//   - Rust 1.85 or later with the `wasm32-unknown-unknown` target:
+//     `rustup target add wasm32-unknown-unknown`
+//   - The copy and remove Lens modules from the DefraDB repository, built and
+//     copied into this directory:
+//     git clone --branch v0.19.0 https://github.com/sourcenetwork/defradb
+//     for lens in copy remove; do
+//     cargo build --release --target wasm32-unknown-unknown \
+//     --manifest-path defradb/tests/lenses/rust_wasm32_$lens/Cargo.toml
+//     cp defradb/tests/lenses/rust_wasm32_$lens/target/wasm32-unknown-unknown/release/rust_wasm32_$lens.wasm .
+//     done
+
+// lensDir is the directory holding the compiled copy and remove Lens modules.
+var lensDir = flag.String("lens-dir", ".", "directory holding rust_wasm32_copy.wasm and rust_wasm32_remove.wasm")
+
+func main() {
+	flag.Parse()
+	ctx := context.Background()
+
+	// --- Step 1: Set up DefraDB with a Lens runtime ---
+	log.Println("================================================================================")
+	log.Println("Set up DefraDB and add schema version 1")
+	log.Println("================================================================================")
+
+	// Migrations need a WebAssembly runtime to execute Lens modules. Wazero is
+	// a pure Go runtime, so it works without any extra system libraries.
+	log.Println("Setting up DefraDB...")
+	db, err := node.New(
+		ctx,
+		node.WithBadgerInMemory(true),
+		node.WithDisableAPI(true),
+		node.WithDisableP2P(true),
+		node.WithLensRuntime(node.Wazero),
+	)
+	if err != nil {
+		log.Fatalf("Failed to create DefraDB node: %v", err)
+	}
+	defer db.Close(ctx)
+	err = db.Start(ctx)
+	if err != nil {
+		log.Fatalf("Failed to start DefraDB node: %v", err)
+	}
+
+	log.Println("Adding 'User' collection schema to DefraDB...")
+	_, err = db.DB.AddSchema(ctx, `type User {
+		name: String
+	}`)
+	if err != nil {
+		log.Fatalf("Failed to add schema: %v", err)
+	}
+
+	// --- Step 2: Create documents under version 1 ---
+	for _, name := range []string{"Ada Lovelace", "Grace Hopper"} {
+		createResult := db.DB.ExecRequest(ctx, `mutation CreateUser($name: String) {
+			create_User(input: {name: $name}) {
+				_docID
+			}
+		}`, client.WithVariables(map[string]any{"name": name}))
+		if len(createResult.GQL.Errors) > 0 {
+			for _, gqlErr := range createResult.GQL.Errors {
+				log.Printf("GraphQL error on create: %v\n", gqlErr)
+			}
+			log.Fatalf("Failed to create document in DefraDB.")
+		}
+	}
+	log.Println("Documents under schema version 1:")
+	printUsers(ctx, db, "name")
+
+	// --- Step 3: Patch the schema and register the migration ---
+	log.Println("================================================================================")
+	log.Println("Migrate to schema version 2")
+	log.Println("================================================================================")
+
+	// Lens modules are referenced by URL, so we need an absolute path.
+	absLensDir, err := filepath.Abs(*lensDir)
+	if err != nil {
+		log.Fatalf("Failed to resolve Lens module directory: %v", err)
+	}
+
+	// The patch is a JSON Patch against the collection's schema description.
+	// Adding a field creates a new schema version. The migration describes how
+	// to turn a version 1 document into a version 2 document. Its modules run
+	// in order: the copy module copies the value of `src` into `dst`, then the
+	// remove module drops `target`, which together rename `name`.
+	//
+	// Setting the new version as the default means that all queries from now
+	// on run against version 2.
+	log.Println("Patching the schema to add 'fullName' with a rename migration...")
+	err = db.DB.PatchSchema(
+		ctx,
+		`[
+			{ "op": "add", "path": "/User/Fields/-", "value": {"Name": "fullName", "Kind": "String"} }
+		]`,
+		immutable.Some(model.Lens{
+			Lenses: []model.LensModule{
+				{
+					Path: "file://" + filepath.Join(absLensDir, "rust_wasm32_copy.wasm"),
+					Arguments: map[string]any{
+						"src": "name",
+						"dst": "fullName",
+					},
+				},
+				{
+					Path: "file://" + filepath.Join(absLensDir, "rust_wasm32_remove.wasm"),
+					Arguments: map[string]any{
+						"target": "name",
+					},
+				},
+			},
+		}),
+		true,
+	)
+	if err != nil {
+		log.Fatalf("Failed to patch schema: %v", err)
+	}
+
+	// --- Step 4: Query the documents under version 2 ---
+	// The documents were never rewritten. DefraDB runs the Lens module on each
+	// of them as they are read.
+	log.Println("Documents under schema version 2:")
+	printUsers(ctx, db, "name fullName")
+
+	/* Output:
+	2024/08/02 14:30:12 Documents under schema version 1:
+	2024/08/02 14:30:12  - {"name":"Ada Lovelace"}
+	2024/08/02 14:30:12  - {"name":"Grace Hopper"}
+	...
+	2024/08/02 14:30:12 Documents under schema version 2:
+	2024/08/02 14:30:12  - {"fullName":"Ada Lovelace","name":null}
+	2024/08/02 14:30:12  - {"fullName":"Grace Hopper","name":null}
+	*/
+}
+
+// printUsers logs all User documents, selecting the given fields.
+func printUsers(ctx context.Context, db *node.Node, fields string) {
+	queryResult := db.DB.ExecRequest(ctx, `query {
+		User {
+			`+fields+`
+		}
+	}`)
+	if len(queryResult.GQL.Errors) > 0 {
+		for _, gqlErr := range queryResult.GQL.Errors {
+			log.Printf("GraphQL error on query: %v\n", gqlErr)
+		}
+		log.Fatalf("Failed to query documents from DefraDB.")
+	}
+	data, _ := queryResult.GQL.Data.(map[string]any)
+	users, ok := data["User"].([]map[string]any)
+	if !ok {
+		log.Fatalf("Unexpected query result from DefraDB: %v", queryResult.GQL.Data)
+	}
+	for _, user := range users {
+		out, err := json.Marshal(user)
+		if err != nil {
+			log.Fatalf("Failed to marshal document: %v", err)
+		}
+		log.Printf(" - %s\n", out)
+	}
+}
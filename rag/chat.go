@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"     // OpenAI client, compatible with Ollama's API
+	"github.com/sourcenetwork/defradb/node" // DefraDB node
+)
+
+// runChat answers questions read from stdin, one per line, until stdin is
+// closed or the user types "exit".
+//
+// Each question goes through the same retrieval and generation steps as the
+// demo. With -memory, similar turns from earlier in the conversation (or from
+// earlier runs, when using -store) are added to the context, and every new turn
+// is saved. The conversation of the session is also sent to the LLM along with
+// each question, so that follow-up questions can refer to earlier ones. Once it
+// exceeds -history-tokens, its older turns are summarized (see
+// compressHistory), so that it doesn't crowd out the retrieved documents.
+func runChat(ctx context.Context, db *node.Node, collections []string) {
+	log.Println("================================================================================")
+	log.Println("Interactive chat (type \"exit\" or press Ctrl-D to quit)")
+	log.Println("================================================================================")
+
+	var history []openai.ChatCompletionMessage
+	scanner := bufio.NewScanner(os.Stdin)
+	for {
+		fmt.Print("> ")
+		if !scanner.Scan() {
+			break
+		}
+		question := strings.TrimSpace(scanner.Text())
+		if question == "" {
+			continue
+		}
+		if question == "exit" {
+			break
+		}
+
+		queryVector, err := embedQuery(ctx, question)
+		if err != nil {
+			// The chat goes on, since Ollama may well be back for the next
+			// question.
+			log.Printf("Failed to create query embedding: %v\n", err)
+			fmt.Println("The embedding service is unavailable, please try again later.")
+			continue
+		}
+		docs, err := retrieve(ctx, db, collections, queryVector)
+		if err != nil {
+			log.Printf("Failed to search the knowledge base: %v\n", err)
+			fmt.Println("The knowledge base couldn't be searched, please try again.")
+			continue
+		}
+		if *memoryFlag {
+			docs = append(docs, recallTurns(ctx, db, queryVector)...)
+		}
+		logDocs(docs)
+
+		// The answer is printed while the LLM writes it, like in chat
+		// applications, rather than after a silent wait.
+		var answer string
+		if confident(docs) {
+			answer = askLLM(ctx, formatContexts(docs), history, question, func(token string) {
+				fmt.Print(token)
+			})
+			fmt.Println()
+		} else {
+			answer = insufficientContextAnswer
+			fmt.Println(answer)
+		}
+
+		if *memoryFlag {
+			saveTurn(ctx, db, question, answer)
+			history = append(history,
+				openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: "Question: " + question},
+				openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: answer},
+			)
+			if estimateMessagesTokens(history) > *historyTokensFlag {
+				history = compressHistory(ctx, history)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		log.Fatalf("Failed to read from stdin: %v", err)
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"os"
+
+	"github.com/mattn/go-isatty" // Terminal detection
+)
+
+// ANSI escape codes for the colors used in the logs.
+const (
+	colorReset  = "\033[0m"
+	colorCyan   = "\033[36m"
+	colorYellow = "\033[33m"
+)
+
+// useColor reports whether the logs are colorized. The logs are written to
+// stderr, so colors are only used when stderr is a terminal, and never when
+// the NO_COLOR environment variable is set (see https://no-color.org/).
+var useColor = os.Getenv("NO_COLOR") == "" &&
+	(isatty.IsTerminal(os.Stderr.Fd()) || isatty.IsCygwinTerminal(os.Stderr.Fd()))
+
+// colorize wraps s in the given color if colors are enabled.
+func colorize(color string, s string) string {
+	if !useColor {
+		return s
+	}
+	return color + s + colorReset
+}
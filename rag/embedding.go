@@ -0,0 +1,379 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/sashabaranov/go-openai"       // OpenAI client, compatible with Ollama's API
+	"github.com/sourcenetwork/defradb/client" // DefraDB client
+	"github.com/sourcenetwork/defradb/node"   // DefraDB node
+	"go.opentelemetry.io/otel/attribute"      // Span attributes
+	"go.opentelemetry.io/otel/trace"          // Tracing API
+)
+
+const (
+	// indexCollection records which embedding model the documents of a
+	// persistent store were embedded with.
+	indexCollection = "RagIndex"
+
+	// embedBatchSize is the number of documents embedded per request when we
+	// embed documents ourselves instead of letting DefraDB do it.
+	embedBatchSize = 32
+)
+
+// embeddingDim is the dimension of the first embedding seen in this run. Every
+// embedding after it must have the same dimension.
+var embeddingDim int
+
+// embeddingPrefix is the text some embedding models expect in front of the
+// texts they embed, depending on whether a text is stored or searched for.
+type embeddingPrefix struct {
+	Document string
+	Query    string
+}
+
+// modelPrefixes are the prefixes of the embedding models that need them.
+// 'nomic-embed-text' performs better when documents for storage and queries for
+// retrieval are told apart. Models without an entry get no prefix, so changing
+// embeddingModel to one of them disables the prefixes, unless -doc-prefix or
+// -query-prefix are set.
+var modelPrefixes = map[string]embeddingPrefix{
+	"nomic-embed-text": {Document: "search_document: ", Query: "search_query: "},
+}
+
+// checkDimension validates the dimension of an embedding against the first one
+// seen, and exits with an explanation if they differ.
+//
+// Vectors of different dimensions can't be meaningfully compared. This usually
+// means that the embedding model was changed after the documents were stored.
+func checkDimension(source string, dim int) {
+	if embeddingDim == 0 {
+		embeddingDim = dim
+		log.Printf("Embedding dimension is %d (from %s).\n", dim, source)
+		return
+	}
+	if dim != embeddingDim {
+		log.Fatalf("Embedding dimension mismatch: %s has %d dimensions, but %d were expected. "+
+			"The documents were probably embedded with a different model than %q. "+
+			"Re-run with -reindex to re-embed them.", source, dim, embeddingDim, embeddingModel)
+	}
+}
+
+// embeddingRequest returns the request creating the embeddings of input with
+// the current embedding model.
+//
+// With -embed-dimensions, the request asks for vectors of reduced dimension.
+// Models trained for it (e.g. with Matryoshka representation learning, like
+// 'nomic-embed-text' v1.5) keep most of their quality with far fewer
+// dimensions, which saves storage and speeds up similarity search.
+func embeddingRequest(input []string) openai.EmbeddingRequest {
+	return openai.EmbeddingRequest{
+		Input:      input,
+		Model:      embeddingModel,
+		Dimensions: *embedDimensionsFlag,
+	}
+}
+
+// checkRequestedDimension exits if an embedding doesn't have the dimension
+// requested with -embed-dimensions. Providers that don't support reducing the
+// dimension ignore the request instead of failing.
+func checkRequestedDimension(dim int) {
+	if *embedDimensionsFlag > 0 && dim != *embedDimensionsFlag {
+		log.Fatalf("Requested embeddings with %d dimensions, but %q returned %d. "+
+			"The model or Ollama version may not support reducing the dimension.", *embedDimensionsFlag, embeddingModel, dim)
+	}
+}
+
+// embedMissing creates the embeddings of the document inputs that don't have
+// one yet, and assigns them to `text_v`.
+//
+// DefraDB's `@embedding` directive always creates embeddings of the model's
+// full dimension. With -embed-dimensions, we create them ourselves instead, so
+// that documents and queries are embedded the same way. -dedup also needs the
+// embeddings before the documents are stored.
+func embedMissing(ctx context.Context, inputs []map[string]any) {
+	var missing []map[string]any
+	var texts []string
+	for _, input := range inputs {
+		if _, ok := input["text_v"]; !ok {
+			missing = append(missing, input)
+			texts = append(texts, input["text"].(string))
+		}
+	}
+	if len(missing) == 0 {
+		return
+	}
+	log.Printf("Embedding %d documents...\n", len(missing))
+	for i, vector := range embedDocuments(ctx, texts) {
+		missing[i]["text_v"] = vector
+	}
+}
+
+// storedDimension returns the dimension of the embeddings stored in a
+// collection, or 0 if the collection has no embedded documents.
+func storedDimension(ctx context.Context, db *node.Node, collection string) int {
+	queryResult := execRequest(ctx, db, fmt.Sprintf(`query {
+		%s(limit: 1) {
+			text_v
+		}
+	}`, collection))
+	if len(queryResult.GQL.Errors) > 0 {
+		for _, gqlErr := range queryResult.GQL.Errors {
+			log.Printf("GraphQL error on query: %v\n", gqlErr)
+		}
+		log.Fatalf("Failed to query documents from DefraDB.")
+	}
+	var resultData map[string][]struct {
+		TextV []float32 `json:"text_v"`
+	}
+	err := decodeData(queryResult.GQL.Data, &resultData)
+	if err != nil {
+		log.Fatalf("Unexpected query result from DefraDB: %v", err)
+	}
+	docs := resultData[collection]
+	if len(docs) == 0 {
+		return 0
+	}
+	return len(docs[0].TextV)
+}
+
+// checkEmbeddingIndex makes sure the documents of a persistent store were
+// embedded with the current embedding model.
+//
+// The first run against a store records the model and dimension in the
+// RagIndex collection. Later runs compare against it and refuse to continue
+// with a different model, since querying with it would give meaningless
+// similarities. With -reindex, the stored documents are instead re-embedded
+// with the current model and the record is updated.
+//
+// stored lists the collections that already existed in the store, i.e. whose
+// documents were embedded by a previous run.
+func checkEmbeddingIndex(ctx context.Context, db *node.Node, collections []string, stored []string) {
+	if !collectionExists(ctx, db, indexCollection) {
+		log.Printf("Adding '%s' collection schema to DefraDB...\n", indexCollection)
+		_, err := db.DB.AddSchema(ctx, fmt.Sprintf(`type %s {
+			model: String
+			dimension: Int
+		}`, indexCollection))
+		if err != nil {
+			log.Fatalf("Failed to add schema: %v", err)
+		}
+	}
+
+	docID, model, dimension := readIndex(ctx, db)
+
+	// The dimension is part of the index too, since -embed-dimensions can
+	// change it without changing the model. We compare against the requested
+	// dimension, or the one seen so far (e.g. by the warm-up), if any.
+	current := fmt.Sprintf("%q", embeddingModel)
+	wantDim := *embedDimensionsFlag
+	if wantDim == 0 {
+		wantDim = embeddingDim
+	}
+	if wantDim > 0 {
+		current += fmt.Sprintf(" (%d dimensions)", wantDim)
+	}
+	changed := model != embeddingModel || (wantDim > 0 && dimension != wantDim)
+
+	switch {
+	case docID != "" && !changed:
+		checkDimension(fmt.Sprintf("the index recorded in the store (model %q)", model), dimension)
+		return
+
+	case docID != "" && !*reindexFlag:
+		log.Fatalf("The store was indexed with embedding model %q (%d dimensions), but the current model is %s. "+
+			"Re-run with -reindex to re-embed the stored documents with the current model.", model, dimension, current)
+
+	case docID != "":
+		log.Printf("Re-indexing the store from model %q (%d dimensions) to %s...\n", model, dimension, current)
+		for _, collection := range stored {
+			reindexCollection(ctx, db, collection, fieldFilter{})
+		}
+		if collectionExists(ctx, db, chatTurnCollection) {
+			reindexCollection(ctx, db, chatTurnCollection, fieldFilter{})
+		}
+	}
+
+	for _, collection := range collections {
+		if dim := storedDimension(ctx, db, collection); dim > 0 {
+			checkDimension(fmt.Sprintf("the documents in '%s'", collection), dim)
+		}
+	}
+	// Nothing to record until some documents have been embedded.
+	if embeddingDim > 0 {
+		writeIndex(ctx, db, docID, embeddingDim)
+	}
+}
+
+// readIndex returns the recorded embedding model and dimension of the store.
+// docID is empty if nothing has been recorded yet.
+func readIndex(ctx context.Context, db *node.Node) (docID string, model string, dimension int) {
+	queryResult := execRequest(ctx, db, fmt.Sprintf(`query {
+		%s(limit: 1) {
+			_docID
+			model
+			dimension
+		}
+	}`, indexCollection))
+	if len(queryResult.GQL.Errors) > 0 {
+		for _, gqlErr := range queryResult.GQL.Errors {
+			log.Printf("GraphQL error on query: %v\n", gqlErr)
+		}
+		log.Fatalf("Failed to query the embedding index from DefraDB.")
+	}
+	var resultData map[string][]struct {
+		DocID     string `json:"_docID"`
+		Model     string `json:"model"`
+		Dimension int    `json:"dimension"`
+	}
+	err := decodeData(queryResult.GQL.Data, &resultData)
+	if err != nil {
+		log.Fatalf("Unexpected query result from DefraDB: %v", err)
+	}
+	docs := resultData[indexCollection]
+	if len(docs) == 0 {
+		return "", "", 0
+	}
+	return docs[0].DocID, docs[0].Model, docs[0].Dimension
+}
+
+// writeIndex records the current embedding model and the given dimension,
+// updating the existing record if docID is not empty.
+func writeIndex(ctx context.Context, db *node.Node, docID string, dimension int) {
+	input := map[string]any{
+		"model":     embeddingModel,
+		"dimension": dimension,
+	}
+	var result *client.RequestResult
+	if docID == "" {
+		result = execRequest(
+			ctx,
+			db,
+			fmt.Sprintf(`mutation Create($input: [%[1]sMutationInputArg!]!) {
+				create_%[1]s(input: $input) {
+					_docID
+				}
+			}`, indexCollection),
+			client.WithVariables(map[string]any{"input": input}),
+		)
+	} else {
+		result = execRequest(
+			ctx,
+			db,
+			// The docID argument of updates is a list of IDs.
+			fmt.Sprintf(`mutation Update($docID: [ID!], $input: %[1]sMutationInputArg!) {
+				update_%[1]s(docID: $docID, input: $input) {
+					_docID
+				}
+			}`, indexCollection),
+			client.WithVariables(map[string]any{"docID": []string{docID}, "input": input}),
+		)
+	}
+	if len(result.GQL.Errors) > 0 {
+		for _, gqlErr := range result.GQL.Errors {
+			log.Printf("GraphQL error on mutation: %v\n", gqlErr)
+		}
+		log.Fatalf("Failed to record the embedding index in DefraDB.")
+	}
+}
+
+// reindexCollection re-embeds every document of a collection with the current
+// embedding model, or only those matching filter if it is set (see
+// -reindex-filter).
+//
+// DefraDB generates embeddings with the model configured in the collection's
+// `@embedding` directive, which was fixed when the collection was created.
+// Instead of relying on it, we generate the new embeddings ourselves and
+// assign them to `text_v` directly. Since `text` doesn't change, DefraDB
+// keeps the vectors we provide.
+func reindexCollection(ctx context.Context, db *node.Node, collection string, filter fieldFilter) {
+	var queryResult *client.RequestResult
+	if filter.Field == "" {
+		queryResult = execRequest(ctx, db, fmt.Sprintf(`query {
+			%s {
+				_docID
+				text
+			}
+		}`, collection))
+	} else {
+		queryResult = execRequest(
+			ctx,
+			db,
+			fmt.Sprintf(`query Filtered($value: String) {
+				%s(filter: {%s: {_eq: $value}}) {
+					_docID
+					text
+				}
+			}`, collection, filter.Field),
+			client.WithVariables(map[string]any{"value": filter.Value}),
+		)
+	}
+	if len(queryResult.GQL.Errors) > 0 {
+		for _, gqlErr := range queryResult.GQL.Errors {
+			log.Printf("GraphQL error on query: %v\n", gqlErr)
+		}
+		log.Fatalf("Failed to query documents from DefraDB.")
+	}
+	var resultData map[string][]struct {
+		DocID string `json:"_docID"`
+		Text  string `json:"text"`
+	}
+	err := decodeData(queryResult.GQL.Data, &resultData)
+	if err != nil {
+		log.Fatalf("Unexpected query result from DefraDB: %v", err)
+	}
+	docs := resultData[collection]
+	log.Printf("Re-embedding %d documents in '%s'...\n", len(docs), collection)
+
+	texts := make([]string, len(docs))
+	for i, doc := range docs {
+		// The stored text already carries the document prefix.
+		texts[i] = doc.Text
+	}
+	vectors := embedDocuments(ctx, texts)
+
+	for i, doc := range docs {
+		updateResult := execRequest(
+			ctx,
+			db,
+			fmt.Sprintf(`mutation Update($docID: [ID!], $vector: [Float32!]) {
+				update_%s(docID: $docID, input: {text_v: $vector}) {
+					_docID
+				}
+			}`, collection),
+			client.WithVariables(map[string]any{"docID": []string{doc.DocID}, "vector": vectors[i]}),
+		)
+		if len(updateResult.GQL.Errors) > 0 {
+			for _, gqlErr := range updateResult.GQL.Errors {
+				log.Printf("GraphQL error on update: %v\n", gqlErr)
+			}
+			log.Fatalf("Failed to update document embedding in DefraDB.")
+		}
+	}
+}
+
+// embedDocuments creates the embeddings of the given document texts with the
+// current embedding model, in batches. The texts must already carry the
+// document prefix.
+func embedDocuments(ctx context.Context, texts []string) [][]float32 {
+	ctx, span := tracer.Start(ctx, "ollama.embed.documents", trace.WithAttributes(
+		attribute.String("ollama.model", embeddingModel),
+		attribute.Int("rag.documents", len(texts)),
+	))
+	defer span.End()
+	vectors := make([][]float32, 0, len(texts))
+	for start := 0; start < len(texts); start += embedBatchSize {
+		batch, err := textEmbedder.Embed(ctx, texts[start:min(start+embedBatchSize, len(texts))])
+		if err != nil {
+			endSpan(span, err)
+			log.Fatalf("Failed to create document embeddings: %v", err)
+		}
+		for _, vector := range batch {
+			checkRequestedDimension(len(vector))
+			checkDimension("the document embeddings", len(vector))
+			vectors = append(vectors, vector)
+		}
+	}
+	return vectors
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+func TestWriteIndex(t *testing.T) {
+	ctx := context.Background()
+	db := newTestNode(t)
+	_, err := db.DB.AddSchema(ctx, fmt.Sprintf(`type %s {
+		model: String
+		dimension: Int
+	}`, indexCollection))
+	if err != nil {
+		t.Fatalf("Failed to add schema: %v", err)
+	}
+
+	writeIndex(ctx, db, "", 768)
+	docID, model, dimension := readIndex(ctx, db)
+	if docID == "" || model != embeddingModel || dimension != 768 {
+		t.Fatalf("recorded %q, %q, %d, want a document with %q and 768", docID, model, dimension, embeddingModel)
+	}
+
+	// A second write updates the record instead of adding one.
+	writeIndex(ctx, db, docID, 256)
+	updatedID, _, dimension := readIndex(ctx, db)
+	if updatedID != docID || dimension != 256 {
+		t.Errorf("recorded %q with %d dimensions, want %q with 256", updatedID, dimension, docID)
+	}
+}
+
+func TestReindexCollection(t *testing.T) {
+	ctx := context.Background()
+	db := newTestNode(t)
+	// Without `@embedding`, DefraDB keeps whatever vectors it is given.
+	_, err := db.DB.AddSchema(ctx, `type Doc {
+		text: String
+		text_v: [Float32!]
+	}`)
+	if err != nil {
+		t.Fatalf("Failed to add schema: %v", err)
+	}
+	errs := createDocument(ctx, db, "Doc", map[string]any{"text": "a", "text_v": []float32{1, 0}})
+	if len(errs) > 0 {
+		t.Fatalf("Failed to create document: %v", errs)
+	}
+
+	useEmbedder(t, &fakeEmbedder{vectors: map[string][]float32{"a": {0, 1}}})
+	reindexCollection(ctx, db, "Doc", fieldFilter{})
+
+	queryResult := execRequest(ctx, db, `query {
+		Doc {
+			text_v
+		}
+	}`)
+	if len(queryResult.GQL.Errors) > 0 {
+		t.Fatalf("Failed to query documents: %v", queryResult.GQL.Errors)
+	}
+	var resultData struct {
+		Doc []struct {
+			TextV []float32 `json:"text_v"`
+		}
+	}
+	err = decodeData(queryResult.GQL.Data, &resultData)
+	if err != nil {
+		t.Fatalf("Unexpected query result: %v", err)
+	}
+	if len(resultData.Doc) != 1 || fmt.Sprint(resultData.Doc[0].TextV) != "[0 1]" {
+		t.Errorf("stored %v, want the new embedding [0 1]", resultData.Doc)
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/sashabaranov/go-openai"                 // OpenAI client, compatible with Ollama's API
+	"github.com/sourcenetwork/defradb/node"             // DefraDB node
+	"github.com/sourcenetwork/examples/internal/shared" // Helpers shared by the examples
+)
+
+// gradePrompt asks the LLM to judge an answer against the expected one.
+const gradePrompt = `You are grading the answer to a question against the expected answer.
+The answer is correct if it contains the same facts as the expected answer, even if it is worded differently or gives more detail.
+
+Question: %s
+Expected answer: %s
+Answer: %s
+
+Reply with a single word: CORRECT or INCORRECT.`
+
+// qaPair is a question with its expected answer, from the evaluation file.
+type qaPair struct {
+	Question       string `json:"question"`
+	ExpectedAnswer string `json:"expectedAnswer"`
+}
+
+// evalResult is the outcome of running the pipeline on a single qaPair.
+type evalResult struct {
+	qaPair
+	Answer  string
+	Hit     bool
+	Correct bool
+}
+
+// runEval runs every question of the JSONL file at path through the retrieval
+// and generation steps, and reports how well the pipeline did.
+//
+// Two things are measured for each question:
+//   - Retrieval hit: whether any retrieved document contains the expected
+//     answer (ignoring case). This tells if the knowledge base search found
+//     the information needed.
+//   - Correctness: whether the LLM's answer matches the expected answer, as
+//     judged by the LLM itself (see gradeAnswer). Answers are rarely worded
+//     exactly like the expected one, so a plain string comparison won't do.
+func runEval(ctx context.Context, db *node.Node, collections []string, path string) {
+	log.Println("================================================================================")
+	log.Println("Evaluating the RAG pipeline on " + path)
+	log.Println("================================================================================")
+	pairs := readQAPairs(path)
+
+	results := make([]evalResult, 0, len(pairs))
+	for i, pair := range pairs {
+		log.Printf("Question %d/%d: %s\n", i+1, len(pairs), pair.Question)
+		queryVector, err := embedQuery(ctx, pair.Question)
+		if err != nil {
+			log.Fatalf("Failed to create query embedding: %v", err)
+		}
+		docs, err := retrieve(ctx, db, collections, queryVector)
+		if err != nil {
+			log.Fatalf("Failed to search the knowledge base: %v", err)
+		}
+		answer := insufficientContextAnswer
+		if confident(docs) {
+			answer = askLLM(ctx, formatContexts(docs), nil, pair.Question, nil)
+		}
+
+		hit := false
+		for _, doc := range docs {
+			if strings.Contains(strings.ToLower(doc.Text), strings.ToLower(pair.ExpectedAnswer)) {
+				hit = true
+				break
+			}
+		}
+		results = append(results, evalResult{
+			qaPair:  pair,
+			Answer:  answer,
+			Hit:     hit,
+			Correct: gradeAnswer(ctx, pair, answer),
+		})
+	}
+	printEvalResults(results)
+}
+
+// readQAPairs reads the question and expected answer pairs from the JSONL file
+// at path.
+func readQAPairs(path string) []qaPair {
+	f, err := os.Open(path)
+	if err != nil {
+		log.Fatalf("Failed to open %s. Make sure the file exists. Error: %v", path, err)
+	}
+	defer f.Close()
+
+	var pairs []qaPair
+	d := json.NewDecoder(f)
+	for {
+		var pair qaPair
+		err := d.Decode(&pair)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			log.Fatalf("Failed to decode JSON line: %v", err)
+		}
+		if pair.Question == "" || pair.ExpectedAnswer == "" {
+			log.Fatalf("Every line of %s needs a question and an expectedAnswer.", path)
+		}
+		pairs = append(pairs, pair)
+	}
+	if len(pairs) == 0 {
+		log.Fatalf("No questions found in %s.", path)
+	}
+	return pairs
+}
+
+// gradeAnswer asks the LLM whether answer is a correct answer to the question
+// of pair.
+func gradeAnswer(ctx context.Context, pair qaPair, answer string) bool {
+	reply, err := textGenerator.Generate(ctx, "", []openai.ChatCompletionMessage{
+		{
+			Role:    openai.ChatMessageRoleUser,
+			Content: fmt.Sprintf(gradePrompt, pair.Question, pair.ExpectedAnswer, answer),
+		},
+	})
+	if err != nil {
+		log.Fatalf("Ollama chat completion failed: %v", err)
+	}
+	// Small models don't always stick to a single word, so we only look at
+	// how the reply starts.
+	verdict := strings.ToUpper(strings.TrimSpace(reply))
+	return strings.HasPrefix(verdict, "CORRECT")
+}
+
+// printEvalResults prints the per-question details and a summary to stdout.
+func printEvalResults(results []evalResult) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "#\tHIT\tCORRECT\tQUESTION\tEXPECTED\tANSWER")
+	hits, correct := 0, 0
+	for i, r := range results {
+		if r.Hit {
+			hits++
+		}
+		if r.Correct {
+			correct++
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\n", i+1, yesNo(r.Hit), yesNo(r.Correct),
+			shared.Truncate(r.Question, 50), shared.Truncate(r.ExpectedAnswer, 30), shared.Truncate(strings.ReplaceAll(r.Answer, "\n", " "), 60))
+	}
+	w.Flush()
+
+	n := float64(len(results))
+	fmt.Println()
+	fmt.Printf("Retrieval hit rate: %d/%d (%.0f%%)\n", hits, len(results), float64(hits)/n*100)
+	fmt.Printf("Answer correctness: %d/%d (%.0f%%)\n", correct, len(results), float64(correct)/n*100)
+}
+
+// yesNo formats a boolean for the results table.
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/sashabaranov/go-openai" // OpenAI client, compatible with Ollama's API
+)
+
+func TestGradeAnswer(t *testing.T) {
+	pair := qaPair{Question: "When was Monarch founded?", ExpectedAnswer: "1896"}
+	tests := []struct {
+		verdict string
+		want    bool
+	}{
+		{verdict: "CORRECT", want: true},
+		{verdict: "INCORRECT", want: false},
+		// Small models don't always stick to a single word.
+		{verdict: " correct.\n", want: true},
+		{verdict: "Correct, the answer gives the same year.", want: true},
+		{verdict: "Incorrect, the expected answer is 1896.", want: false},
+		{verdict: "The answer is correct.", want: false},
+		{verdict: "", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.verdict, func(t *testing.T) {
+			g := &fakeGenerator{replies: []openai.ChatCompletionMessage{
+				{Role: openai.ChatMessageRoleAssistant, Content: tt.verdict},
+			}}
+			useGenerator(t, g)
+
+			got := gradeAnswer(context.Background(), pair, "Monarch was founded in 1896.")
+			if got != tt.want {
+				t.Errorf("gradeAnswer with the verdict %q returned %v, want %v", tt.verdict, got, tt.want)
+			}
+			// The grading prompt must give the LLM the question, the expected
+			// answer and the answer to grade.
+			prompt := g.requests[0][0].Content
+			for _, s := range []string{pair.Question, pair.ExpectedAnswer, "Monarch was founded in 1896."} {
+				if !strings.Contains(prompt, s) {
+					t.Errorf("the grading prompt %q doesn't contain %q", prompt, s)
+				}
+			}
+		})
+	}
+}
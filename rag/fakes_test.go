@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"     // OpenAI client, compatible with Ollama's API
+	"github.com/sourcenetwork/defradb/node" // DefraDB node
+)
+
+// fakeEmbedder is an Embedder returning fixed vectors, so that the example can
+// be tested without Ollama. It embeds each text to its vector in vectors, or
+// fails with err if it is set. The texts it was asked to embed are recorded in
+// texts.
+type fakeEmbedder struct {
+	vectors map[string][]float32
+	err     error
+	texts   []string
+}
+
+// Embed implements Embedder.
+func (e *fakeEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	e.texts = append(e.texts, texts...)
+	if e.err != nil {
+		return nil, e.err
+	}
+	vectors := make([][]float32, 0, len(texts))
+	for _, text := range texts {
+		vectors = append(vectors, e.vectors[text])
+	}
+	return vectors, nil
+}
+
+// useEmbedder replaces textEmbedder with e for the duration of the test.
+//
+// The embedding dimension seen first is remembered across the program (see
+// checkDimension), so it is forgotten too, and every test starts afresh.
+func useEmbedder(t *testing.T, e Embedder) {
+	previous, previousDim := textEmbedder, embeddingDim
+	textEmbedder, embeddingDim = e, 0
+	t.Cleanup(func() {
+		textEmbedder, embeddingDim = previous, previousDim
+	})
+}
+
+// fakeGenerator is a Generator replying with scripted messages, so that the
+// example can be tested without an LLM. Each request gets the next message of
+// replies, or fails with err if it is set. The requests are recorded.
+type fakeGenerator struct {
+	replies []openai.ChatCompletionMessage
+	err     error
+
+	systems  []string
+	requests [][]openai.ChatCompletionMessage
+	tools    [][]openai.Tool
+}
+
+// Generate implements Generator.
+func (g *fakeGenerator) Generate(ctx context.Context, system string, messages []openai.ChatCompletionMessage) (string, error) {
+	reply, err := g.next(system, messages, nil)
+	return reply.Content, err
+}
+
+// GenerateStream implements Generator. The reply is streamed word by word.
+func (g *fakeGenerator) GenerateStream(ctx context.Context, system string, messages []openai.ChatCompletionMessage, onToken func(string)) (string, error) {
+	reply, err := g.next(system, messages, nil)
+	if err != nil {
+		return "", err
+	}
+	for _, token := range strings.SplitAfter(reply.Content, " ") {
+		onToken(token)
+	}
+	return reply.Content, nil
+}
+
+// GenerateWithTools implements Generator.
+func (g *fakeGenerator) GenerateWithTools(ctx context.Context, system string, messages []openai.ChatCompletionMessage, tools []openai.Tool) (openai.ChatCompletionMessage, error) {
+	return g.next(system, messages, tools)
+}
+
+func (g *fakeGenerator) next(system string, messages []openai.ChatCompletionMessage, tools []openai.Tool) (openai.ChatCompletionMessage, error) {
+	g.systems = append(g.systems, system)
+	g.requests = append(g.requests, append([]openai.ChatCompletionMessage{}, messages...))
+	g.tools = append(g.tools, tools)
+	if g.err != nil {
+		return openai.ChatCompletionMessage{}, g.err
+	}
+	if len(g.replies) == 0 {
+		return openai.ChatCompletionMessage{}, errors.New("fakeGenerator: no more replies")
+	}
+	reply := g.replies[0]
+	g.replies = g.replies[1:]
+	return reply, nil
+}
+
+// useGenerator replaces textGenerator with g for the duration of the test.
+func useGenerator(t *testing.T, g Generator) {
+	previous := textGenerator
+	textGenerator = g
+	t.Cleanup(func() {
+		textGenerator = previous
+	})
+}
+
+// newTestNode starts an in-memory DefraDB node, closed at the end of the test.
+func newTestNode(t *testing.T) *node.Node {
+	ctx := context.Background()
+	db, err := node.New(ctx, node.WithBadgerInMemory(true), node.WithDisableAPI(true), node.WithDisableP2P(true))
+	if err != nil {
+		t.Fatalf("Failed to create DefraDB node: %v", err)
+	}
+	t.Cleanup(func() { db.Close(ctx) })
+	err = db.Start(ctx)
+	if err != nil {
+		t.Fatalf("Failed to start DefraDB node: %v", err)
+	}
+	return db
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Command line flags. Every flag has a default that reproduces the canned demo,
+// so `go run .` works without any arguments.
+var (
+	// collectionsFlag is a comma-separated list of collections making up the
+	// knowledge base. Each collection is loaded from a JSONL file named after it
+	// in lower case, e.g. `Wiki` is loaded from `wiki.jsonl`.
+	collectionsFlag = flag.String("collections", "Wiki", "comma-separated list of collections to ingest into and retrieve from")
+
+	// dataFlag is a glob pattern of the JSONL files to load, in which
+	// `{collection}` stands for the lower-case collection name. When empty,
+	// each collection is loaded from a file named after it. See dataFiles.
+	dataFlag = flag.String("data", "", "glob pattern of the JSONL files to load, e.g. 'corpus/*.jsonl' ({collection} is replaced by the collection name)")
+
+	// storeFlag is the directory DefraDB persists its data in. When empty,
+	// DefraDB runs in memory and everything is lost on exit.
+	storeFlag = flag.String("store", "", "directory to persist DefraDB data in (in-memory if empty)")
+
+	// demoFlag narrates the demo, comparing the answers without and with RAG.
+	// With -demo=false, the question is asked once with RAG, and only the
+	// answer is printed to stdout.
+	demoFlag = flag.Bool("demo", true, "narrate the demo; with -demo=false, only print the answer to stdout")
+
+	// jsonFlag prints the retrieved documents and the answer as JSON lines
+	// rather than the bare answer, with -demo=false. See printJSON.
+	jsonFlag = flag.Bool("json", false, "with -demo=false, print the retrieved documents and the answer as JSON lines")
+
+	// interactiveFlag replaces the canned demo with a chat loop reading
+	// questions from stdin.
+	interactiveFlag = flag.Bool("interactive", false, "chat interactively instead of running the demo")
+
+	// evalFlag replaces the canned demo with an evaluation of the pipeline on
+	// the questions of a JSONL file. See runEval.
+	evalFlag = flag.String("eval", "", "evaluate the pipeline on a JSONL file of {question, expectedAnswer} pairs")
+
+	// toolsFlag lets the LLM search the knowledge base itself with function
+	// calling, falling back to classic RAG if the model doesn't support it.
+	// See askWithTools.
+	toolsFlag = flag.Bool("tools", false, "let the LLM search the knowledge base with function calling")
+
+	// memoryFlag stores every chat turn in DefraDB and adds similar past turns
+	// to the context of new questions.
+	memoryFlag = flag.Bool("memory", false, "remember the conversation in DefraDB (requires -interactive)")
+
+	// historyTokensFlag is the estimated number of tokens the conversation
+	// sent with each question may use before its older turns are summarized.
+	// See compressHistory.
+	historyTokensFlag = flag.Int("history-tokens", 512, "estimated token budget of the conversation history before it is summarized (with -memory)")
+
+	// recencyWeightFlag is the weight given to how recent a document is when
+	// ranking retrieved documents. 0 ranks by similarity alone, 1 by recency
+	// alone. See scoreByRecency.
+	recencyWeightFlag = flag.Float64("recency-weight", 0, "weight of document recency vs. similarity when ranking, between 0 and 1")
+
+	// mmrLambdaFlag selects the retrieved documents by Maximal Marginal
+	// Relevance, trading relevance (1) for diversity (0). The default of 1
+	// ranks by relevance alone. See selectMMR.
+	mmrLambdaFlag = flag.Float64("mmr-lambda", 1, "balance of relevance (1) and diversity (0) of the retrieved documents, between 0 and 1")
+
+	// retrievalTimeoutFlag caps how long the similarity search of a question
+	// may take, across all collections. See retrieve.
+	retrievalTimeoutFlag = flag.Duration("retrieval-timeout", 30*time.Second, "maximum time to search the knowledge base for a question (no limit if 0)")
+
+	// embedConcurrencyFlag is the number of documents created in parallel
+	// during ingestion. Every document is embedded by Ollama when it is
+	// created, and a local Ollama is easily overwhelmed, so the default is low.
+	embedConcurrencyFlag = flag.Int("embed-concurrency", 2, "number of documents embedded in parallel during ingestion")
+
+	// embedDimensionsFlag requests embeddings of reduced dimension from the
+	// embedding model. 0 keeps the model's full dimension. See
+	// embeddingRequest.
+	embedDimensionsFlag = flag.Int("embed-dimensions", 0, "dimension of the embeddings to request (0 for the model's full dimension)")
+
+	// maxDocSizeFlag is the size in bytes above which a document is handled
+	// according to onOversizeFlag, either "skip" or "chunk". 0 disables the
+	// limit, the default. See loadDocuments.
+	maxDocSizeFlag = flag.Int("max-doc-size", 0, "size in bytes above which a document is oversized (0 for no limit)")
+	onOversizeFlag = flag.String("on-oversize", "skip", "what to do with oversized documents: skip or chunk")
+
+	// onParseErrorFlag is what to do with the lines of the data files that
+	// aren't valid JSON: "skip" them with a warning, or "abort" the load.
+	onParseErrorFlag = flag.String("on-parse-error", "skip", "what to do with invalid lines of the data files: skip or abort")
+
+	// dedupFlag skips documents that are near-duplicates of one stored or
+	// ingested before them. See dedupDocuments.
+	dedupFlag = flag.Bool("dedup", false, "skip near-duplicate documents during ingestion")
+
+	// dedupThresholdFlag is the similarity above which a document counts as a
+	// near-duplicate.
+	dedupThresholdFlag = flag.Float64("dedup-threshold", 0.95, "similarity above which a document is a near-duplicate (with -dedup)")
+
+	// minConfidenceFlag is the similarity the most similar retrieved document
+	// must reach for the LLM to be asked. Below it, a canned answer is given
+	// instead. The default of -1 disables the check. See confident.
+	minConfidenceFlag = flag.Float64("min-confidence", -1, "similarity of the best document below which no answer is attempted, between -1 and 1")
+
+	// fallbackFlag answers a question the knowledge base has no documents for
+	// with the LLM's own knowledge, with a disclaimer, instead of giving up.
+	fallbackFlag = flag.Bool("fallback", false, "answer from the LLM's own knowledge, with a disclaimer, when no document is retrieved")
+
+	// maxContextTokensFlag is the estimated number of tokens the prompt may
+	// use. Ollama's default context window is 2048 tokens, and the answer
+	// needs some of it too.
+	maxContextTokensFlag = flag.Int("max-context-tokens", 1536, "estimated token budget for the prompt; lowest-ranked contexts are dropped to fit")
+
+	// seedFlag makes the answers reproducible: it is passed to the LLM, and
+	// sets the temperature to 0 and top_p to 1 unless they are given. It also
+	// ingests documents one at a time, in file order. A negative seed disables
+	// it. See chatRequest.
+	seedFlag = flag.Int("seed", -1, "seed for reproducible answers, with a temperature of 0 (disabled if negative)")
+
+	// temperatureFlag and topPFlag are the sampling options of the LLM. When
+	// not set, the model's defaults are used.
+	temperatureFlag = flag.Float64("temperature", -1, "sampling temperature of the LLM (model default if negative)")
+	topPFlag        = flag.Float64("top-p", 0, "nucleus sampling probability of the LLM, between 0 and 1 (model default if 0)")
+
+	// presencePenaltyFlag and frequencyPenaltyFlag discourage the LLM from
+	// repeating itself, and maxTokensFlag limits the length of its answers.
+	// 0 keeps the model's defaults. See chatRequest.
+	presencePenaltyFlag  = flag.Float64("presence-penalty", 0, "penalty for tokens already in the answer, between -2 and 2")
+	frequencyPenaltyFlag = flag.Float64("frequency-penalty", 0, "penalty for tokens by how often they are in the answer, between -2 and 2")
+	maxTokensFlag        = flag.Int("max-tokens", 0, "maximum number of tokens in an answer (model default if 0)")
+
+	// printPromptFlag writes the rendered prompt of every LLM request to
+	// stderr, to see exactly what the model receives.
+	printPromptFlag = flag.Bool("print-prompt", false, "print the prompt sent to the LLM to stderr")
+
+	// printQueryFlag writes the similarity query sent to DefraDB and its
+	// variables to stderr, to paste them into a GraphQL client.
+	printQueryFlag = flag.Bool("print-query", false, "print the GraphQL similarity query and its variables to stderr")
+
+	// printConfigFlag prints the effective value of every flag, secrets
+	// masked, and exits. See printConfig.
+	printConfigFlag = flag.Bool("print-config", false, "print the effective configuration, with secrets masked, and exit")
+
+	// promptFileFlag is a text/template file replacing the built-in system
+	// prompt template. See loadPromptTemplate.
+	promptFileFlag = flag.String("prompt-file", "", "file with a custom system prompt template (built-in template if empty)")
+
+	// autoPullFlag pulls the chat and embedding models into Ollama if they are
+	// missing, instead of exiting with the command to do so. See checkModels.
+	autoPullFlag = flag.Bool("auto-pull", false, "pull missing Ollama models instead of exiting")
+
+	// traceEndpointFlag is the OTLP/HTTP endpoint to export OpenTelemetry
+	// traces to. Tracing is disabled when empty. See setupTracing.
+	traceEndpointFlag = flag.String("trace-endpoint", "", "OTLP/HTTP endpoint to export traces to, e.g. http://localhost:4318 (disabled if empty)")
+
+	// warmupFlag loads both models into Ollama before the workflow starts. See
+	// warmup.
+	warmupFlag = flag.Bool("warmup", true, "load the chat and embedding models before starting")
+
+	// docPrefixFlag and queryPrefixFlag are prepended to stored documents and
+	// to queries before embedding them. They default to the prefixes of the
+	// embedding model (see modelPrefixes). The documents of a persistent store
+	// keep the prefix they were stored with.
+	docPrefixFlag   = flag.String("doc-prefix", modelPrefixes[embeddingModel].Document, "prefix of documents before embedding them")
+	queryPrefixFlag = flag.String("query-prefix", modelPrefixes[embeddingModel].Query, "prefix of queries before embedding them")
+
+	// llmURLFlag, llmModelFlag and apiKeyFlag select the chat LLM. By default,
+	// it is the local Ollama, which needs no API key. See provider.go.
+	//
+	// The API key falls back to OPENAI_API_KEY after the flags are parsed.
+	// Making it the flag's default would print it with the usage.
+	llmURLFlag   = flag.String("llm-url", ollamaBaseURL, "base URL of the OpenAI-compatible API serving the chat LLM")
+	llmModelFlag = flag.String("llm-model", llmModel, "chat LLM to answer with")
+	apiKeyFlag   = flag.String("api-key", "", "API key of the chat LLM provider (default $OPENAI_API_KEY)")
+
+	// rateLimitRPSFlag throttles the requests to the chat and embedding APIs,
+	// which hosted providers limit. 0 disables throttling. Rate-limited
+	// requests are retried either way. See rateLimitTransport.
+	rateLimitRPSFlag = flag.Float64("rate-limit-rps", 0, "maximum number of requests per second to the chat and embedding APIs (0 for no limit)")
+
+	// ingestFilterFlag only loads the JSON lines whose field has the given
+	// value, e.g. `category=History`. See fieldFilter.
+	ingestFilterFlag = flag.String("ingest-filter", "", "only load the documents matching field=value, e.g. category=History")
+
+	// reindexFilterFlag re-embeds the stored documents whose field has the
+	// given value, without re-embedding the whole store. See
+	// reindexCollection.
+	reindexFilterFlag = flag.String("reindex-filter", "", "re-embed the stored documents matching field=value (category or source, requires -store)")
+
+	// reindexFlag re-embeds the documents of a persistent store with the
+	// current embedding model if it differs from the one they were embedded
+	// with. See checkEmbeddingIndex.
+	reindexFlag = flag.Bool("reindex", false, "re-embed stored documents if the embedding model changed (requires -store)")
+)
+
+// collectionNamePattern matches valid GraphQL type names, which is what
+// DefraDB collection names are. Field names follow the same rules.
+var collectionNamePattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*$`)
+
+// parseCollections splits the value of the -collections flag into collection
+// names, and exits if any of them is not a valid collection name.
+func parseCollections(value string) []string {
+	var collections []string
+	seen := map[string]bool{}
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" || seen[name] {
+			continue
+		}
+		if !collectionNamePattern.MatchString(name) {
+			log.Fatalf("Invalid collection name %q: must start with a letter and contain only letters, digits and underscores.", name)
+		}
+		seen[name] = true
+		collections = append(collections, name)
+	}
+	if len(collections) == 0 {
+		log.Fatalf("At least one collection is required.")
+	}
+	return collections
+}
+
+// fieldFilter selects the documents whose Field is equal to Value. The zero
+// value selects all documents.
+type fieldFilter struct {
+	Field string
+	Value string
+}
+
+// parseFieldFilter parses a filter flag of the form `field=value`, and exits
+// if it is malformed. An empty value gives the zero fieldFilter.
+func parseFieldFilter(name string, value string) fieldFilter {
+	if value == "" {
+		return fieldFilter{}
+	}
+	field, v, ok := strings.Cut(value, "=")
+	if !ok || !collectionNamePattern.MatchString(field) {
+		log.Fatalf("Invalid -%s %q: must be field=value, e.g. category=History.", name, value)
+	}
+	return fieldFilter{Field: field, Value: v}
+}
+
+// readQuestion returns the question to ask in the demo. It is taken from the
+// positional arguments if there are any, e.g. `go run . "Who founded Monarch?"`,
+// or from stdin if it is piped, e.g. `echo "Who founded Monarch?" | go run .`.
+// Otherwise, the canned demo question is used.
+func readQuestion() string {
+	var question string
+	switch {
+	case flag.NArg() > 0:
+		question = strings.Join(flag.Args(), " ")
+	case stdinIsPiped():
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			log.Fatalf("Failed to read question from stdin: %v", err)
+		}
+		question = string(data)
+	default:
+		return defaultQuestion
+	}
+
+	question = strings.TrimSpace(question)
+	if question == "" {
+		log.Fatalf("The question is empty.")
+	}
+	return question
+}
+
+// stdinIsPiped reports whether stdin is a pipe or file rather than a terminal.
+func stdinIsPiped() bool {
+	info, err := os.Stdin.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice == 0
+}
+
+// isFlagSet reports whether the flag with the given name was given on the
+// command line, rather than left to its default.
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
@@ -0,0 +1,531 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/sourcenetwork/defradb/client"           // DefraDB client
+	"github.com/sourcenetwork/defradb/node"             // DefraDB node
+	"github.com/sourcenetwork/examples/internal/shared" // Helpers shared by the examples
+	"go.opentelemetry.io/otel/attribute"                // Span attributes
+	"go.opentelemetry.io/otel/trace"                    // Tracing API
+)
+
+// maxLineSize is the maximum length of a line of a JSONL file. A line with a
+// precomputed 768-dimension embedding takes about 16 KB.
+const maxLineSize = 1024 * 1024
+
+// progressInterval is how often the progress of ingestion is logged.
+const progressInterval = 5 * time.Second
+
+// minDocSize is the smallest -max-doc-size. Smaller chunks would hardly hold a
+// sentence.
+const minDocSize = 64
+
+// addSchema adds a collection for knowledge base documents to DefraDB.
+//
+// A schema in DefraDB is similar to a table definition in a traditional database.
+// The key part for RAG is the `@embedding` directive.
+//   - `category`, `title: String`: Optional labels of the document. They are
+//     passed to the LLM with the text (see formatContexts).
+//   - `date: DateTime`: An optional date, used when ranking by recency.
+//   - `source: String`: The name of the file the document was loaded from.
+//   - `textHash: String @index`: A hash of the article text, used to skip
+//     documents that are already stored when loading again (see
+//     loadDocuments). The index makes looking them up cheap.
+//   - `text_v: [Float32!]`: This defines a field to store the vector embedding.
+//   - `@embedding(...)`: This directive tells DefraDB to automatically generate
+//     an embedding for this field.
+//   - `fields: ["text"]`: Specifies that the embedding should be generated from
+//     the content of the "text" field.
+//   - `provider: "ollama"`: The embedding provider to use.
+//   - `model: "nomic-embed-text"`: The specific model to use for generating embeddings.
+//     It is fixed when the collection is created (see checkEmbeddingIndex).
+//
+// Every collection of the knowledge base shares this shape, so that they can
+// all be searched with the same similarity query.
+func addSchema(ctx context.Context, db *node.Node, collection string) {
+	log.Printf("Adding '%s' collection schema to DefraDB...\n", collection)
+	_, err := db.DB.AddSchema(ctx, fmt.Sprintf(`type %[1]s {
+		text: String
+		category: String
+		title: String
+		date: DateTime
+		source: String
+		textHash: String @index
+		text_v: [Float32!] @embedding(fields: ["text"], provider: "ollama", model: "%[2]s")
+	}`, collection, embeddingModel))
+	if err != nil {
+		// Existing collections are skipped before we get here (see
+		// collectionExists), so any error is unexpected.
+		log.Fatalf("Failed to add schema: %v", err)
+	}
+}
+
+// collectionExists reports whether a collection with the given name has
+// already been added to DefraDB, e.g. by a previous run using the same store.
+func collectionExists(ctx context.Context, db *node.Node, collection string) bool {
+	_, err := db.DB.GetCollectionByName(ctx, collection)
+	return err == nil
+}
+
+// countDocuments returns the number of documents in a collection.
+func countDocuments(ctx context.Context, db *node.Node, collection string) int {
+	result := execRequest(ctx, db, fmt.Sprintf(`query {
+		_count(%s: {})
+	}`, collection))
+	if len(result.GQL.Errors) > 0 {
+		for _, gqlErr := range result.GQL.Errors {
+			log.Printf("GraphQL error on count: %v\n", gqlErr)
+		}
+		log.Fatalf("Failed to count the documents of '%s'.", collection)
+	}
+	var data struct {
+		Count int `json:"_count"`
+	}
+	err := decodeData(result.GQL.Data, &data)
+	if err != nil {
+		log.Fatalf("Unexpected count result from DefraDB: %v", err)
+	}
+	return data.Count
+}
+
+// dataFiles returns the JSONL files a collection is loaded from.
+//
+// By default, a collection is loaded from a single file named after it, e.g.
+// `Wiki` from `wiki.jsonl`. With -data, it is loaded from all the files
+// matching a glob pattern, e.g. `corpus/*.jsonl`. In the pattern,
+// `{collection}` stands for the lower-case collection name, so that several
+// collections can be loaded from different files, e.g.
+// `corpus/{collection}/*.jsonl`.
+func dataFiles(collection string) []string {
+	name := strings.ToLower(collection)
+	if *dataFlag == "" {
+		return []string{name + ".jsonl"}
+	}
+	pattern := strings.ReplaceAll(*dataFlag, "{collection}", name)
+	paths, err := filepath.Glob(pattern)
+	if err != nil {
+		log.Fatalf("Invalid -data pattern %q: %v", pattern, err)
+	}
+	if len(paths) == 0 {
+		log.Fatalf("No file matches -data pattern %q.", pattern)
+	}
+	return paths
+}
+
+// loadDocuments reads the JSONL files at paths and adds each line as a document
+// to the given collection. Every document records the name of its file in
+// its `source` field. If filter is set (see -ingest-filter), only the lines
+// whose field has the given value are loaded.
+//
+// Ingestion is idempotent: every document stores a hash of its article text,
+// and articles whose hash is already in the collection are skipped. Loading
+// the same file into a persistent store again only adds what is missing, e.g.
+// after an interrupted run, without creating duplicates or paying for their
+// embeddings again.
+//
+// Lines that aren't valid JSON are skipped with a warning, so that a single
+// bad line doesn't prevent loading a large dataset. The number of skipped
+// lines is logged at the end.
+//
+// Creating a document makes DefraDB request its embedding from Ollama, which
+// is by far the slowest part of ingestion. Up to -embed-concurrency documents
+// are created in parallel by a bounded pool of workers. The results are
+// collected by line, so errors are reported in file order no matter which
+// worker hit them.
+func loadDocuments(ctx context.Context, db *node.Node, collection string, paths []string, filter fieldFilter) {
+	stored := storedHashes(ctx, db, collection)
+	var inputs []map[string]any
+	// origins holds the file and line of each input by text hash, for error
+	// messages. Inputs can be dropped by dedupDocuments, so they can't be
+	// matched by position.
+	origins := map[string]string{}
+	skipped, precomputed, invalid, oversized, filtered := 0, 0, 0, 0, 0
+	for _, path := range paths {
+		// We'll load our knowledge base from local JSONL files. Each line in a
+		// file represents a document (a small Wiki article in this case).
+		f, err := os.Open(path)
+		if err != nil {
+			log.Fatalf("Failed to open %s. Make sure the file exists. Error: %v", path, err)
+		}
+		log.Printf("Reading JSON lines from %s and adding to the '%s' collection...\n", path, collection)
+
+		// We read the file line by line rather than with a json.Decoder, which
+		// can't resume after a syntax error. Lines with a precomputed
+		// embedding can be longer than the scanner's default limit.
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(nil, maxLineSize)
+		for line := 1; scanner.Scan(); line++ {
+			if strings.TrimSpace(scanner.Text()) == "" {
+				continue
+			}
+			var article struct {
+				Text     string `json:"text"`
+				Category string `json:"category"`
+				Title    string `json:"title"`
+				Date     string `json:"date"`
+				// Embedding is an optional precomputed embedding of the text.
+				Embedding []float32 `json:"embedding"`
+			}
+			err := json.Unmarshal(scanner.Bytes(), &article)
+			if err != nil {
+				// The offending content is shown, so that the line can be
+				// found and fixed, but truncated, as it may be very long.
+				if *onParseErrorFlag == "abort" {
+					log.Fatalf("Invalid JSON on line %d of %s: %v: %s", line, path, err, shared.Truncate(scanner.Text(), 100))
+				}
+				log.Printf("Warning: skipping line %d of %s: %v: %s\n", line, path, err, shared.Truncate(scanner.Text(), 100))
+				invalid++
+				continue
+			}
+			if filter.Field != "" && !matchesFilter(scanner.Bytes(), filter) {
+				filtered++
+				continue
+			}
+
+			// A document larger than -max-doc-size can exceed what the embedding
+			// model accepts, failing the whole load, or fill the LLM's context
+			// on its own. Depending on -on-oversize, it is skipped, or split
+			// into chunks that are stored as separate documents.
+			texts := []string{article.Text}
+			if *maxDocSizeFlag > 0 && len(article.Text) > *maxDocSizeFlag {
+				oversized++
+				if *onOversizeFlag == "skip" {
+					log.Printf("Warning: skipping line %d of %s: the document is %d bytes, more than -max-doc-size.\n", line, path, len(article.Text))
+					continue
+				}
+				texts = chunkText(article.Text, *maxDocSizeFlag)
+				// A precomputed embedding is of the whole text, not of the
+				// chunks, so they are embedded like any other document.
+				article.Embedding = nil
+			}
+			for _, text := range texts {
+				hash := textHash(text)
+				if stored[hash] {
+					skipped++
+					continue
+				}
+				stored[hash] = true
+
+				// The 'nomic-embed-text' model performs better when a specific prefix is
+				// added to differentiate between documents for storage ("search_document")
+				// and queries for retrieval ("search_query"). This is a model-specific
+				// requirement and not needed for all embedding models, which is why the
+				// prefix is configurable with -doc-prefix (see modelPrefixes).
+				// We add the prefix here before storing the document.
+				contentWithPrefix := *docPrefixFlag + text
+
+				input := map[string]any{
+					"text":     contentWithPrefix,
+					"category": article.Category,
+					"title":    article.Title,
+					"source":   filepath.Base(path),
+					"textHash": hash,
+				}
+				// The date is optional. Documents without one are treated as the
+				// oldest when ranking by recency.
+				if article.Date != "" {
+					input["date"] = article.Date
+				}
+				// Embeddings may be produced elsewhere in a pipeline. If a line
+				// carries one, we assign it to `text_v` directly, and DefraDB doesn't
+				// have to compute it again. It must have been created the same way as
+				// ours: with the same model, from the text with the document prefix.
+				if len(article.Embedding) > 0 {
+					checkRequestedDimension(len(article.Embedding))
+					checkDimension(fmt.Sprintf("the precomputed embedding on line %d of %s", line, path), len(article.Embedding))
+					input["text_v"] = article.Embedding
+					precomputed++
+				}
+				inputs = append(inputs, input)
+				origins[hash] = fmt.Sprintf("%s:%d", path, line)
+			}
+		}
+		err = scanner.Err()
+		f.Close()
+		if err != nil {
+			log.Fatalf("Failed to read %s: %v", path, err)
+		}
+	}
+	if invalid > 0 {
+		log.Printf("Skipped %d invalid lines.\n", invalid)
+	}
+	if filtered > 0 {
+		log.Printf("Ignored %d documents not matching %s=%s.\n", filtered, filter.Field, filter.Value)
+	}
+	if oversized > 0 && *onOversizeFlag == "skip" {
+		log.Printf("Skipped %d documents larger than %d bytes.\n", oversized, *maxDocSizeFlag)
+	} else if oversized > 0 {
+		log.Printf("Split %d documents larger than %d bytes into chunks.\n", oversized, *maxDocSizeFlag)
+	}
+	if skipped > 0 {
+		log.Printf("Skipped %d documents already in the '%s' collection.\n", skipped, collection)
+	}
+	if precomputed > 0 {
+		log.Printf("Using precomputed embeddings for %d documents.\n", precomputed)
+	}
+	if *dedupFlag {
+		inputs = dedupDocuments(ctx, inputs, storedVectors(ctx, db, collection), *dedupThresholdFlag)
+	}
+	if *embedDimensionsFlag > 0 {
+		embedMissing(ctx, inputs)
+	}
+
+	ctx, span := tracer.Start(ctx, "rag.ingest", trace.WithAttributes(
+		attribute.String("rag.collection", collection),
+		attribute.Int("rag.documents", len(inputs)),
+		attribute.Int("rag.concurrency", *embedConcurrencyFlag),
+	))
+	defer span.End()
+	start := time.Now()
+	errs := make([][]error, len(inputs))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	var created atomic.Int64
+	for range *embedConcurrencyFlag {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				errs[i] = createDocument(ctx, db, collection, inputs[i])
+				created.Add(1)
+			}
+		}()
+	}
+	stopProgress := reportProgress(&created, len(inputs), start)
+	for i := range inputs {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	stopProgress()
+
+	failed := false
+	for i, gqlErrs := range errs {
+		for _, gqlErr := range gqlErrs {
+			log.Printf("GraphQL error on create (%s): %v\n", origins[inputs[i]["textHash"].(string)], gqlErr)
+			failed = true
+		}
+	}
+	if failed {
+		log.Fatalf("Failed to create documents in DefraDB.")
+	}
+
+	elapsed := time.Since(start)
+	log.Printf("Loaded %d documents in %s (%.1f docs/s with %d workers).\n",
+		len(inputs), elapsed.Round(time.Millisecond), float64(len(inputs))/elapsed.Seconds(), *embedConcurrencyFlag)
+}
+
+// reportProgress logs how many of total documents have been created, and at
+// what rate, every progressInterval until the returned function is called.
+// Loading a large dataset can take a long time, which would otherwise pass
+// without any sign of life.
+func reportProgress(created *atomic.Int64, total int, start time.Time) (stop func()) {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(progressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				n := created.Load()
+				log.Printf("Created %d/%d documents (%.1f docs/s)...\n", n, total, float64(n)/time.Since(start).Seconds())
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// matchesFilter reports whether the field of a JSON line has the value of the
+// filter. Any field of the line can be filtered on, not only those stored.
+func matchesFilter(line []byte, filter fieldFilter) bool {
+	var fields map[string]any
+	if json.Unmarshal(line, &fields) != nil {
+		return false
+	}
+	value, ok := fields[filter.Field]
+	return ok && fmt.Sprint(value) == filter.Value
+}
+
+// chunkText splits a text into chunks of at most maxSize bytes, between words.
+// A word longer than maxSize is split too, between characters.
+func chunkText(text string, maxSize int) []string {
+	var chunks []string
+	var b strings.Builder
+	flush := func() {
+		if b.Len() > 0 {
+			chunks = append(chunks, b.String())
+			b.Reset()
+		}
+	}
+	for _, word := range strings.Fields(text) {
+		for len(word) > maxSize {
+			flush()
+			cut := maxSize
+			for !utf8.RuneStart(word[cut]) {
+				cut--
+			}
+			chunks = append(chunks, word[:cut])
+			word = word[cut:]
+		}
+		if b.Len() > 0 && b.Len()+1+len(word) > maxSize {
+			flush()
+		}
+		if b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(word)
+	}
+	flush()
+	return chunks
+}
+
+// textHash returns the idempotency key of an article: the hex-encoded SHA-256
+// hash of its text.
+func textHash(text string) string {
+	sum := sha256.Sum256([]byte(text))
+	return hex.EncodeToString(sum[:])
+}
+
+// storedHashes returns the text hashes of the documents already in the given
+// collection.
+func storedHashes(ctx context.Context, db *node.Node, collection string) map[string]bool {
+	queryResult := execRequest(ctx, db, fmt.Sprintf(`query {
+		%s {
+			textHash
+		}
+	}`, collection))
+	if len(queryResult.GQL.Errors) > 0 {
+		for _, gqlErr := range queryResult.GQL.Errors {
+			log.Printf("GraphQL error on query: %v\n", gqlErr)
+		}
+		log.Fatalf("Failed to query stored documents from DefraDB.")
+	}
+
+	var resultData map[string][]struct {
+		TextHash string `json:"textHash"`
+	}
+	err := decodeData(queryResult.GQL.Data, &resultData)
+	if err != nil {
+		log.Fatalf("Unexpected query result from DefraDB: %v", err)
+	}
+	hashes := map[string]bool{}
+	for _, doc := range resultData[collection] {
+		hashes[doc.TextHash] = true
+	}
+	return hashes
+}
+
+// storedVectors returns the embeddings of the documents already stored in the
+// collection, so that -dedup also catches near-duplicates of the documents
+// loaded by a previous run of a persistent store.
+func storedVectors(ctx context.Context, db *node.Node, collection string) [][]float32 {
+	queryResult := execRequest(ctx, db, fmt.Sprintf(`query {
+		%s {
+			text_v
+		}
+	}`, collection))
+	if len(queryResult.GQL.Errors) > 0 {
+		for _, gqlErr := range queryResult.GQL.Errors {
+			log.Printf("GraphQL error on query: %v\n", gqlErr)
+		}
+		log.Fatalf("Failed to query stored documents from DefraDB.")
+	}
+
+	var resultData map[string][]struct {
+		TextV []float32 `json:"text_v"`
+	}
+	err := decodeData(queryResult.GQL.Data, &resultData)
+	if err != nil {
+		log.Fatalf("Unexpected query result from DefraDB: %v", err)
+	}
+	var vectors [][]float32
+	for _, doc := range resultData[collection] {
+		vectors = append(vectors, doc.TextV)
+	}
+	return vectors
+}
+
+// createDocument adds a single document to the given collection and returns
+// the GraphQL errors, if any.
+func createDocument(ctx context.Context, db *node.Node, collection string, input map[string]any) []error {
+	// We use a GraphQL mutation to create a new document in the collection.
+	// The `input` argument for a `create` mutation is a document (can also be a list of documents).
+	// When this mutation is executed, DefraDB will:
+	// 1. Take the value of `text`.
+	// 2. Send it to the configured Ollama model (`nomic-embed-text`).
+	// 3. Store the resulting vector embedding in the `text_v` field.
+	//
+	// Note that we could also generate the embedding manually and assign it to
+	// `text_v`, which is what -dedup and -embed-dimensions do (see
+	// dedupDocuments and embedMissing).
+	createResult := execRequest(
+		ctx,
+		db,
+		fmt.Sprintf(`mutation Create($input: [%[1]sMutationInputArg!]!) {
+			create_%[1]s(input: $input) {
+				_docID
+			}
+		}`, collection),
+		client.WithVariables(map[string]any{
+			// Since we are creating one document at a time, we provide
+			// a single document object.
+			"input": input,
+		}),
+	)
+	return createResult.GQL.Errors
+}
+
+// dedupDocuments drops documents that are near-duplicates of a stored one or
+// of an earlier one, i.e. whose cosine similarity to a stored document or to a
+// document kept before them is above threshold.
+//
+// Near-duplicates crowd the retrieval results with the same information. To
+// find them, we embed the documents ourselves (unless they come with a
+// precomputed embedding) and compare each one to an in-memory index of the
+// stored vectors and of the vectors kept so far, in file order. The vectors
+// are assigned to `text_v`, so DefraDB doesn't have to embed the kept
+// documents again.
+func dedupDocuments(ctx context.Context, inputs []map[string]any, stored [][]float32, threshold float64) []map[string]any {
+	embedMissing(ctx, inputs)
+
+	kept := append([][]float32(nil), stored...)
+	deduped := make([]map[string]any, 0, len(inputs))
+	for _, input := range inputs {
+		vector := input["text_v"].([]float32)
+		duplicate := false
+		for _, k := range kept {
+			if shared.CosineSimilarity(vector, k) > threshold {
+				duplicate = true
+				break
+			}
+		}
+		if duplicate {
+			continue
+		}
+		kept = append(kept, vector)
+		deduped = append(deduped, input)
+	}
+	log.Printf("Skipped %d near-duplicate documents (similarity above %g).\n", len(inputs)-len(deduped), threshold)
+	return deduped
+}
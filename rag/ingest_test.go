@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestDedupDocuments(t *testing.T) {
+	useEmbedder(t, &fakeEmbedder{vectors: map[string][]float32{
+		"a":       {1, 0},
+		"a again": {0.99, 0.1},
+		"b":       {0, 1},
+		"c":       {0.6, 0.8},
+	}})
+
+	// "c" was stored by an earlier run, with a slightly different vector.
+	stored := [][]float32{{0.61, 0.79}}
+	inputs := []map[string]any{{"text": "a"}, {"text": "a again"}, {"text": "b"}, {"text": "c"}}
+	deduped := dedupDocuments(context.Background(), inputs, stored, 0.95)
+
+	var texts []string
+	for _, input := range deduped {
+		texts = append(texts, input["text"].(string))
+	}
+	if len(texts) != 2 || texts[0] != "a" || texts[1] != "b" {
+		t.Errorf("kept %q, want [a b]", texts)
+	}
+	// The kept documents carry their embedding, so that DefraDB doesn't embed
+	// them again.
+	for _, input := range deduped {
+		if _, ok := input["text_v"]; !ok {
+			t.Errorf("document %q has no text_v", input["text"])
+		}
+	}
+}
+
+func TestChunkText(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		maxSize int
+		want    []string
+	}{
+		{name: "empty", text: "", maxSize: 8, want: nil},
+		{name: "fits", text: "aaaa bbbb", maxSize: 9, want: []string{"aaaa bbbb"}},
+		{name: "one byte over", text: "aaaa bbbb", maxSize: 8, want: []string{"aaaa", "bbbb"}},
+		{name: "whitespace collapses", text: " aaaa \n\t bbbb ", maxSize: 9, want: []string{"aaaa bbbb"}},
+		{name: "several words per chunk", text: "aa bb cc dd ee", maxSize: 5, want: []string{"aa bb", "cc dd", "ee"}},
+		{name: "long word", text: "abcdefghij kl", maxSize: 4, want: []string{"abcd", "efgh", "ij", "kl"}},
+		// "é" is 2 bytes long, so a cut after 3 bytes would split it.
+		{name: "multibyte characters", text: "ééé", maxSize: 3, want: []string{"é", "é", "é"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chunks := chunkText(tt.text, tt.maxSize)
+			if !slices.Equal(chunks, tt.want) {
+				t.Errorf("chunkText returned %q, want %q", chunks, tt.want)
+			}
+			for _, chunk := range chunks {
+				if len(chunk) > tt.maxSize {
+					t.Errorf("chunk %q is longer than %d bytes", chunk, tt.maxSize)
+				}
+			}
+			// The chunks don't overlap: put back together, they give the words
+			// of the text, each once.
+			if got, want := strings.Fields(strings.Join(chunks, " ")), strings.Fields(tt.text); strings.Join(got, "") != strings.Join(want, "") {
+				t.Errorf("the chunks put back together give %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestLoadDocumentsInvalidLines(t *testing.T) {
+	ctx := context.Background()
+	db := newTestNode(t)
+	// The valid lines carry their embedding, so DefraDB doesn't need Ollama.
+	// The fake embedder only makes the dimension check start afresh.
+	useEmbedder(t, &fakeEmbedder{})
+	path := filepath.Join(t.TempDir(), "wiki.jsonl")
+	data := `{"text": "a", "embedding": [1, 0]}
+{"text": "broken"
+{"text": "b", "embedding": [0, 1]}
+`
+	err := os.WriteFile(path, []byte(data), 0o644)
+	if err != nil {
+		t.Fatalf("Failed to write %s: %v", path, err)
+	}
+	var logs strings.Builder
+	log.SetOutput(&logs)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	addSchema(ctx, db, "Wiki")
+	loadDocuments(ctx, db, "Wiki", []string{path}, fieldFilter{})
+
+	if n := countDocuments(ctx, db, "Wiki"); n != 2 {
+		t.Errorf("loaded %d documents, want the 2 valid lines", n)
+	}
+	// The warning gives the line number and content, so it can be fixed.
+	for _, want := range []string{"skipping line 2 of " + path, `{"text": "broken"`, "Skipped 1 invalid lines."} {
+		if !strings.Contains(logs.String(), want) {
+			t.Errorf("the logs don't contain %q:\n%s", want, logs.String())
+		}
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+	"unicode/utf8"
+
+	"github.com/sashabaranov/go-openai"  // OpenAI client, compatible with Ollama's API
+	"go.opentelemetry.io/otel/attribute" // Span attributes
+	"go.opentelemetry.io/otel/trace"     // Tracing API
+)
+
+// systemPromptTpl is a Go template for generating the system prompt.
+// A system prompt is a powerful way to guide the LLM's behavior, setting its
+// persona, instructions, and constraints.
+//
+// Prompt engineering is a critical part of building a successful RAG system.
+// The quality of the prompt can significantly impact the quality of the answer.
+//
+// In this prompt:
+//   - We tell the LLM it's a helpful assistant.
+//   - We instruct it to be concise and unbiased.
+//   - When context is provided (the `if .` block), we strictly instruct it to
+//     answer *only* based on the provided search results. This helps prevent the
+//     LLM from "hallucinating" or using its own (potentially outdated or incorrect)
+//     internal knowledge.
+//   - The `<context>` block is a common convention to clearly separate the
+//     retrieved information from the user's question.
+var systemPromptTpl = template.Must(template.New("system_prompt").Parse(`
+You are a helpful assistant with access to a knowlege base, tasked with answering questions about the world and its history, people, places and other things.
+
+Answer the question in a very concise manner. Use an unbiased and journalistic tone. Do not repeat text. Don't make anything up. If you are not sure about something, just say that you don't know.
+{{- /* Stop here if no context is provided. The rest below is for handling contexts. */ -}}
+{{- if . -}}
+Answer the question solely based on the provided search results from the knowledge base. If the search results from the knowledge base are not relevant to the question at hand, just say that you don't know. Don't make anything up.
+
+Anything between the following 'context' XML blocks is retrieved from the knowledge base, not part of the conversation with the user. The bullet points are ordered by relevance, so the first one is the most relevant.
+
+<context>
+    {{- if . -}}
+    {{- range $context := .}}
+    - {{.}}{{end}}
+    {{- end}}
+</context>
+{{- end -}}
+
+Don't mention the knowledge base, context or search results in your answer.
+`))
+
+// askLLM sends a request to the LLM with an optional context and a question.
+// history holds the earlier messages of the conversation, if any (see
+// runChat). If onToken isn't nil, the reply is streamed to it while it is
+// generated.
+func askLLM(ctx context.Context, contexts []string, history []openai.ChatCompletionMessage, question string, onToken func(string)) string {
+	// We use the template to generate the final system prompt, injecting the
+	// retrieved contexts if they exist. If the prompt doesn't fit in the
+	// token budget, the model would silently truncate it, so we drop the
+	// lowest-ranked contexts until it does.
+	userMessage := "Question: " + question
+	systemPrompt := renderSystemPrompt(contexts)
+	historyTokens := estimateMessagesTokens(history)
+	dropped := 0
+	for len(contexts) > 0 && estimateTokens(systemPrompt)+historyTokens+estimateTokens(userMessage) > *maxContextTokensFlag {
+		contexts = contexts[:len(contexts)-1]
+		systemPrompt = renderSystemPrompt(contexts)
+		dropped++
+	}
+	if dropped > 0 {
+		log.Printf("Dropped %d contexts to fit the prompt in %d tokens.\n", dropped, *maxContextTokensFlag)
+	}
+
+	if *printPromptFlag {
+		// The prompt goes to stderr, like the logs, so that it doesn't mix
+		// with answers printed to stdout.
+		fmt.Fprintf(os.Stderr, "----- system prompt -----\n%s\n----- user message -----\n%s\n-------------------------\n", systemPrompt, userMessage)
+	}
+
+	// We construct the chat messages. The conversation consists of:
+	// 1. The system prompt (our instructions to the LLM), which the generator
+	//    adds in front.
+	// 2. The earlier messages of the conversation, if any.
+	// 3. The user's question.
+	messages := append([]openai.ChatCompletionMessage{}, history...)
+	messages = append(messages, openai.ChatCompletionMessage{
+		Role:    openai.ChatMessageRoleUser,
+		Content: userMessage,
+	})
+
+	ctx, span := tracer.Start(ctx, "ollama.chat", trace.WithAttributes(
+		attribute.String("llm.model", *llmModelFlag),
+		attribute.Int("rag.contexts", len(contexts)),
+		attribute.Int("rag.prompt_tokens_estimate", estimateTokens(systemPrompt)+historyTokens+estimateTokens(userMessage)),
+	))
+	// The reply is generated by textGenerator, which talks to the LLM through
+	// the standard OpenAI client. We can use it because Ollama exposes an
+	// OpenAI-compatible API (see provider.go).
+	var reply string
+	var err error
+	if onToken != nil {
+		reply, err = textGenerator.GenerateStream(ctx, systemPrompt, messages, onToken)
+	} else {
+		reply, err = textGenerator.Generate(ctx, systemPrompt, messages)
+	}
+	endSpan(span, err)
+	if err != nil {
+		log.Fatalf("Ollama chat completion failed: %v", err)
+	}
+
+	// The response from the LLM might have leading/trailing whitespace,
+	// so we trim it for a cleaner output.
+	return strings.TrimSpace(reply)
+}
+
+// promptTemplate is the template the system prompt is rendered with. It is the
+// built-in systemPromptTpl, unless a template file is given with -prompt-file.
+var promptTemplate = systemPromptTpl
+
+// chatRequest returns a chat completion request for the LLM with the sampling
+// options of the command line (-temperature, -top-p, -presence-penalty,
+// -frequency-penalty and -max-tokens). By default, the model's own options are
+// used, which include some randomness, so the answers vary from run to run.
+// With -seed, the temperature defaults to 0 and top_p to 1, so that the same
+// prompt gets the same answer.
+func chatRequest(messages []openai.ChatCompletionMessage) openai.ChatCompletionRequest {
+	req := openai.ChatCompletionRequest{
+		Model:    *llmModelFlag,
+		Messages: messages,
+	}
+	temperature, topP := *temperatureFlag, *topPFlag
+	if *seedFlag >= 0 {
+		seed := *seedFlag
+		req.Seed = &seed
+		if temperature < 0 {
+			temperature = 0
+		}
+		if topP <= 0 {
+			topP = 1
+		}
+	}
+	if temperature >= 0 {
+		// go-openai leaves a zero temperature out of the request, which would
+		// give the model's default instead. The smallest positive float is
+		// sent instead, which is as good as zero.
+		req.Temperature = max(float32(temperature), math.SmallestNonzeroFloat32)
+	}
+	if topP > 0 {
+		req.TopP = float32(topP)
+	}
+	req.PresencePenalty = float32(*presencePenaltyFlag)
+	req.FrequencyPenalty = float32(*frequencyPenaltyFlag)
+	req.MaxTokens = *maxTokensFlag
+	return req
+}
+
+// loadPromptTemplate parses the system prompt template in the file at path.
+//
+// Like the built-in template, it is executed with the list of retrieved
+// contexts, which is empty when asking without RAG.
+func loadPromptTemplate(path string) *template.Template {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Fatalf("Failed to read prompt template: %v", err)
+	}
+	tpl, err := template.New(filepath.Base(path)).Parse(string(data))
+	if err != nil {
+		log.Fatalf("Failed to parse prompt template %s: %v", path, err)
+	}
+	return tpl
+}
+
+// renderSystemPrompt generates the system prompt for the given contexts.
+func renderSystemPrompt(contexts []string) string {
+	sb := &strings.Builder{}
+	err := promptTemplate.Execute(sb, contexts)
+	if err != nil {
+		// This should not happen with a valid template.
+		log.Fatalf("Failed to execute system prompt template: %v", err)
+	}
+	return sb.String()
+}
+
+// estimateTokens roughly estimates the number of tokens in s.
+//
+// The exact count depends on the model's tokenizer, but for English text a
+// token is about four characters on average. This is good enough to stay
+// within a budget that leaves some headroom.
+func estimateTokens(s string) int {
+	return (utf8.RuneCountInString(s) + 3) / 4
+}
+
+// estimateMessagesTokens estimates the number of tokens of chat messages.
+func estimateMessagesTokens(messages []openai.ChatCompletionMessage) int {
+	tokens := 0
+	for _, m := range messages {
+		tokens += estimateTokens(m.Content)
+	}
+	return tokens
+}
+
+// warmup loads the chat and embedding models into memory.
+//
+// It can take a few seconds for Ollama to load a model into memory for the
+// first time. We send a trivial request to each model to "warm it up", so that
+// this delay doesn't end up in the timings of the actual workflow. The chat
+// model is asked for a one-word reply, which takes little time once loaded.
+func warmup(ctx context.Context) {
+	log.Println("Warming up Ollama...")
+	start := time.Now()
+	_, err := textGenerator.Generate(ctx, "", []openai.ChatCompletionMessage{
+		{
+			Role:    openai.ChatMessageRoleUser,
+			Content: "Reply with a single word: hello.",
+		},
+	})
+	if err != nil {
+		log.Fatalf("Failed to warm up %s: %v", *llmModelFlag, err)
+	}
+	log.Printf("Loaded %s in %s\n", *llmModelFlag, time.Since(start))
+
+	start = time.Now()
+	vectors, err := textEmbedder.Embed(ctx, []string{*queryPrefixFlag + "Hello"})
+	if err != nil {
+		log.Fatalf("Failed to warm up %s: %v", embeddingModel, err)
+	}
+	log.Printf("Loaded %s in %s\n", embeddingModel, time.Since(start))
+	checkRequestedDimension(len(vectors[0]))
+	checkDimension("the warm-up embedding", len(vectors[0]))
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sashabaranov/go-openai" // OpenAI client, compatible with Ollama's API
+)
+
+func TestAskLLM(t *testing.T) {
+	g := &fakeGenerator{replies: []openai.ChatCompletionMessage{{Content: " In 1930. "}}}
+	useGenerator(t, g)
+
+	answer := askLLM(context.Background(), []string{"[Wiki] The Monarch Company was founded in 1930."}, nil, "When was Monarch founded?", nil)
+	if answer != "In 1930." {
+		t.Errorf("askLLM returned %q, want the trimmed reply", answer)
+	}
+	if !strings.Contains(g.systems[0], "The Monarch Company was founded in 1930.") {
+		t.Errorf("the system prompt doesn't hold the context:\n%s", g.systems[0])
+	}
+	messages := g.requests[0]
+	if len(messages) != 1 || messages[0].Content != "Question: When was Monarch founded?" {
+		t.Errorf("askLLM sent %+v, want only the question", messages)
+	}
+}
+
+func TestAskLLMStream(t *testing.T) {
+	useGenerator(t, &fakeGenerator{replies: []openai.ChatCompletionMessage{{Content: "It was founded in 1930."}}})
+
+	var streamed strings.Builder
+	answer := askLLM(context.Background(), nil, nil, "When was Monarch founded?", func(token string) {
+		streamed.WriteString(token)
+	})
+	if streamed.String() != "It was founded in 1930." || answer != streamed.String() {
+		t.Errorf("streamed %q and returned %q, want the whole reply for both", streamed.String(), answer)
+	}
+}
+
+func TestAskLLMContextBudget(t *testing.T) {
+	question := "When was Monarch founded?"
+	contexts := []string{
+		"[Wiki] The Monarch Company was founded in 1930.",
+		"[Wiki] Monarch made confectionery and syrups.",
+		"[Wiki] Monarch was based in Atlanta.",
+	}
+	// budgetFor is the budget of a prompt holding the first n contexts.
+	budgetFor := func(n int) int {
+		return estimateTokens(renderSystemPrompt(contexts[:n])) + estimateTokens("Question: "+question)
+	}
+	tests := []struct {
+		name   string
+		budget int
+		kept   int
+	}{
+		{name: "everything fits", budget: budgetFor(3), kept: 3},
+		{name: "one token short", budget: budgetFor(3) - 1, kept: 2},
+		{name: "room for one", budget: budgetFor(1), kept: 1},
+		// The contexts are dropped, but the question is still asked.
+		{name: "too small for any", budget: 1, kept: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			previous := *maxContextTokensFlag
+			*maxContextTokensFlag = tt.budget
+			t.Cleanup(func() { *maxContextTokensFlag = previous })
+			g := &fakeGenerator{replies: []openai.ChatCompletionMessage{{Content: "In 1930."}}}
+			useGenerator(t, g)
+
+			askLLM(context.Background(), contexts, nil, question, nil)
+			// The lowest-ranked contexts, last in the list, are dropped first.
+			for i, c := range contexts {
+				if got, want := strings.Contains(g.systems[0], c), i < tt.kept; got != want {
+					t.Errorf("context %d in the prompt: %v, want %v", i+1, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRenderSystemPrompt(t *testing.T) {
+	contexts := formatContexts([]retrievedDoc{{
+		Collection: "Wiki",
+		Title:      "Barnes & Noble's <Store>",
+		Text:       `It's "the" bookseller.`,
+	}})
+	prompt := renderSystemPrompt(contexts)
+	// The prompt is plain text: nothing in it is HTML-escaped.
+	want := `- [Wiki, title: "Barnes & Noble's <Store>"] It's "the" bookseller.`
+	if !strings.Contains(prompt, want) {
+		t.Errorf("the system prompt doesn't hold %s:\n%s", want, prompt)
+	}
+}
+
+func TestLoadPromptTemplate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "prompt.txt")
+	err := os.WriteFile(path, []byte(`Facts:{{range .}} {{.}}{{end}}`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := promptTemplate
+	promptTemplate = loadPromptTemplate(path)
+	t.Cleanup(func() { promptTemplate = previous })
+
+	if prompt := renderSystemPrompt([]string{`"A" & <B>`}); prompt != `Facts: "A" & <B>` {
+		t.Errorf("rendered %q, want the context as is", prompt)
+	}
+}
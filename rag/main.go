@@ -3,17 +3,14 @@ package main
 import (
 	"context"
 	"encoding/json"
-	"html/template"
-	"io"
+	"flag"
+	"fmt"
 	"log"
-	"net/http"
 	"os"
 	"strings"
 	"time"
 
-	"github.com/sashabaranov/go-openai"       // OpenAI client, compatible with Ollama's API
-	"github.com/sourcenetwork/defradb/client" // DefraDB client
-	"github.com/sourcenetwork/defradb/node"   // DefraDB node
+	"github.com/sourcenetwork/defradb/node" // DefraDB node
 )
 
 // This example, based on `github.com/chromem-go/examples/rag-wikipedia-ollama`,
@@ -36,7 +33,10 @@ import (
 // - An Ollama instance running locally. See: https://ollama.com/
 // - The 'nomic-embed-text' model pulled in Ollama: `ollama pull nomic-embed-text`
 // - The 'gemma:2b' model pulled in Ollama: `ollama pull gemma:2b`
-// - A `wiki.jsonl` file in the same directory with sample data.
+// - A `wiki.jsonl` file in the same directory with sample data. When using
+//   `-collections`, one JSONL file per collection, named after the collection
+//   in lower case (e.g. `faq.jsonl` for the `FAQ` collection). Use -data to
+//   load other files.
 
 const (
 	// We use a local LLM running in Ollama to answer the question.
@@ -48,47 +48,201 @@ const (
 	// Model details: https://huggingface.co/google/gemma-2b
 	llmModel = "gemma:2b"
 
-	// The question we want to ask. It's specific enough that a general-purpose
-	// small LLM is unlikely to know the answer.
-	question = "When did the Monarch Company exist?"
+	// The question we ask by default. It's specific enough that a
+	// general-purpose small LLM is unlikely to know the answer. A different
+	// question can be passed as arguments or on stdin (see readQuestion).
+	defaultQuestion = "When did the Monarch Company exist?"
 
 	// We use a local LLM running in Ollama for creating the embeddings.
 	// This model is specifically designed for generating high-quality embeddings.
 	// Model details: https://huggingface.co/nomic-ai/nomic-embed-text-v1.5
 	embeddingModel = "nomic-embed-text"
+
+	// exitInsufficientContext is the exit code of a one-shot run that didn't
+	// answer the question because of -min-confidence.
+	exitInsufficientContext = 2
+
+	// exitEmbeddingUnavailable is the exit code of a one-shot run that couldn't
+	// search the knowledge base because the embedding model was unreachable.
+	exitEmbeddingUnavailable = 3
 )
 
 func main() {
+	flag.Parse()
+	if *apiKeyFlag == "" {
+		*apiKeyFlag = os.Getenv("OPENAI_API_KEY")
+	}
+	redactLogs()
+	if *printConfigFlag {
+		printConfig(os.Stdout)
+		return
+	}
+	collections := parseCollections(*collectionsFlag)
+	if *dataFlag != "" && len(collections) > 1 && !strings.Contains(*dataFlag, "{collection}") {
+		log.Fatalf("-data must contain {collection} when loading several collections.")
+	}
+	if *memoryFlag && !*interactiveFlag {
+		log.Fatalf("-memory requires -interactive.")
+	}
+	if *recencyWeightFlag < 0 || *recencyWeightFlag > 1 {
+		log.Fatalf("-recency-weight must be between 0 and 1, got %v", *recencyWeightFlag)
+	}
+	if *mmrLambdaFlag < 0 || *mmrLambdaFlag > 1 {
+		log.Fatalf("-mmr-lambda must be between 0 and 1, got %v", *mmrLambdaFlag)
+	}
+	if *retrievalTimeoutFlag < 0 {
+		log.Fatalf("-retrieval-timeout must not be negative, got %s", *retrievalTimeoutFlag)
+	}
+	if *dedupThresholdFlag < -1 || *dedupThresholdFlag > 1 {
+		log.Fatalf("-dedup-threshold must be between -1 and 1, got %v", *dedupThresholdFlag)
+	}
+	if *minConfidenceFlag < -1 || *minConfidenceFlag > 1 {
+		log.Fatalf("-min-confidence must be between -1 and 1, got %v", *minConfidenceFlag)
+	}
+	if *rateLimitRPSFlag < 0 {
+		log.Fatalf("-rate-limit-rps must not be negative, got %v", *rateLimitRPSFlag)
+	}
+	if *historyTokensFlag < 1 {
+		log.Fatalf("-history-tokens must be positive, got %d", *historyTokensFlag)
+	}
+	if *maxContextTokensFlag < 1 {
+		log.Fatalf("-max-context-tokens must be positive, got %d", *maxContextTokensFlag)
+	}
+	if *embedDimensionsFlag < 0 {
+		log.Fatalf("-embed-dimensions must not be negative, got %d", *embedDimensionsFlag)
+	}
+	if *maxDocSizeFlag != 0 && *maxDocSizeFlag < minDocSize {
+		log.Fatalf("-max-doc-size must be 0 or at least %d, got %d", minDocSize, *maxDocSizeFlag)
+	}
+	if *onOversizeFlag != "skip" && *onOversizeFlag != "chunk" {
+		log.Fatalf("-on-oversize must be skip or chunk, got %q", *onOversizeFlag)
+	}
+	if *onParseErrorFlag != "skip" && *onParseErrorFlag != "abort" {
+		log.Fatalf("-on-parse-error must be skip or abort, got %q", *onParseErrorFlag)
+	}
+	if *embedConcurrencyFlag < 1 {
+		log.Fatalf("-embed-concurrency must be at least 1, got %d", *embedConcurrencyFlag)
+	}
+	if *temperatureFlag > 2 {
+		log.Fatalf("-temperature must be at most 2, got %v", *temperatureFlag)
+	}
+	if *topPFlag < 0 || *topPFlag > 1 {
+		log.Fatalf("-top-p must be between 0 and 1, got %v", *topPFlag)
+	}
+	if *presencePenaltyFlag < -2 || *presencePenaltyFlag > 2 {
+		log.Fatalf("-presence-penalty must be between -2 and 2, got %v", *presencePenaltyFlag)
+	}
+	if *frequencyPenaltyFlag < -2 || *frequencyPenaltyFlag > 2 {
+		log.Fatalf("-frequency-penalty must be between -2 and 2, got %v", *frequencyPenaltyFlag)
+	}
+	if *maxTokensFlag < 0 {
+		log.Fatalf("-max-tokens must not be negative, got %d", *maxTokensFlag)
+	}
+	if *seedFlag >= 0 && *embedConcurrencyFlag != 1 {
+		// Documents created in parallel are stored in a different order on
+		// each run. One at a time, they are stored in file order.
+		if isFlagSet("embed-concurrency") {
+			log.Fatalf("-seed ingests documents one at a time and conflicts with -embed-concurrency %d.", *embedConcurrencyFlag)
+		}
+		log.Printf("-seed is set: ingesting documents one at a time instead of %d in parallel.\n", *embedConcurrencyFlag)
+		*embedConcurrencyFlag = 1
+	}
+	if *reindexFlag && *storeFlag == "" {
+		log.Fatalf("-reindex requires -store.")
+	}
+	ingestFilter := parseFieldFilter("ingest-filter", *ingestFilterFlag)
+	reindexFilter := parseFieldFilter("reindex-filter", *reindexFilterFlag)
+	if reindexFilter.Field != "" && *storeFlag == "" {
+		log.Fatalf("-reindex-filter requires -store.")
+	}
+	// Only the string fields of the schema can be compared with a string.
+	if reindexFilter.Field != "" && reindexFilter.Field != "category" && reindexFilter.Field != "source" {
+		log.Fatalf("-reindex-filter only supports the category and source fields, got %q", reindexFilter.Field)
+	}
+	if !chatOnOllama() && *apiKeyFlag == "" {
+		log.Fatalf("An API key is required for the chat LLM at %s: set -api-key or OPENAI_API_KEY.", *llmURLFlag)
+	}
+	if *promptFileFlag != "" {
+		promptTemplate = loadPromptTemplate(*promptFileFlag)
+	}
+	if *interactiveFlag && *evalFlag != "" {
+		log.Fatalf("-interactive and -eval can't be used together.")
+	}
+	if *toolsFlag && (*interactiveFlag || *evalFlag != "") {
+		log.Fatalf("-tools can't be used with -interactive or -eval.")
+	}
+	if *jsonFlag && (*demoFlag || *interactiveFlag || *evalFlag != "") {
+		log.Fatalf("-json requires -demo=false, and can't be used with -interactive or -eval.")
+	}
+	oneShot := !*interactiveFlag && *evalFlag == ""
+	var question string
+	if oneShot {
+		question = readQuestion()
+	} else if flag.NArg() > 0 {
+		log.Fatalf("A question can't be passed as an argument with -interactive or -eval.")
+	}
 	ctx := context.Background()
 
-	// // It can take a few seconds for Ollama to load a model into memory for the
-	// // first time. We send a simple request to "warm it up" and ensure it's
-	// // ready before we start the main workflow.
+	// In one-shot mode, the exit code tells other programs whether the
+	// question was answered. It is set by the deferred function registered
+	// first, so that it runs after all the others (closing DefraDB, flushing
+	// traces).
+	exitCode := 0
+	defer func() {
+		if exitCode != 0 {
+			os.Exit(exitCode)
+		}
+	}()
+
+	// With -trace-endpoint, the time spent in DefraDB and Ollama is traced
+	// with OpenTelemetry. See tracing.go.
+	if *traceEndpointFlag != "" {
+		shutdown := setupTracing(ctx, *traceEndpointFlag)
+		defer shutdown(ctx)
+	}
+
+	// Before anything else, we make sure Ollama has the models we need.
+	checkModels(ctx, *autoPullFlag)
+
+	// It can take a few seconds for Ollama to load a model into memory for the
+	// first time. We send a simple request to "warm it up" and ensure it's
+	// ready before we start the main workflow.
+	if *warmupFlag {
+		warmup(ctx)
+	}
 
 	// --- Step 1: Ask the LLM without RAG ---
 	// We first ask the LLM our question directly to demonstrate that without any
-	// external knowledge, it's unable to provide a correct answer.
-	log.Println("================================================================================")
-	log.Println("Asking the LLM without providing any external knowledge (no RAG)")
-	log.Println("================================================================================")
-	log.Println("Question: " + question)
-	log.Println("Asking LLM...")
-	reply := askLLM(ctx, nil, question)
-	log.Printf("Initial reply from the LLM: \"%s\"\n\n", reply)
+	// external knowledge, it's unable to provide a correct answer. This step is
+	// only part of the demo, so it is skipped with -demo=false, and in
+	// interactive and eval modes.
+	if oneShot && *demoFlag {
+		banner("Asking the LLM without providing any external knowledge (no RAG)")
+		log.Println("Question: " + question)
+		log.Println("Asking LLM...")
+		reply := askLLM(ctx, nil, nil, question, nil)
+		log.Printf("Initial reply from the LLM: \"%s\"\n\n", reply)
+	}
 
 	// --- Step 2: Set up DefraDB and load knowledge base ---
 	// Now, we'll use DefraDB to store our knowledge base and retrieve relevant
 	// context for our question.
-	log.Println("================================================================================")
-	log.Println("Set up DefraDB and load knowledge base")
-	log.Println("================================================================================")
+	banner("Set up DefraDB and load knowledge base")
 
-	// For this example, we'll use an in-memory instance of DefraDB.
-	// For production use, you would configure it with persistent storage like Badger.
+	// For this example, we'll use an in-memory instance of DefraDB by default.
+	// With -store, DefraDB persists its data with Badger in the given directory,
+	// so the knowledge base (and the conversation memory) survive restarts.
 	// We also disable the P2P and API servers as we are using DefraDB embedded
 	// in our application.
 	log.Println("Setting up DefraDB...")
-	db, err := node.New(ctx, node.WithBadgerInMemory(true), node.WithDisableAPI(true), node.WithDisableP2P(true))
+	opts := []node.Option{node.WithDisableAPI(true), node.WithDisableP2P(true)}
+	if *storeFlag != "" {
+		opts = append(opts, node.WithStorePath(*storeFlag))
+	} else {
+		opts = append(opts, node.WithBadgerInMemory(true))
+	}
+	_, span := tracer.Start(ctx, "defradb.node.start")
+	db, err := node.New(ctx, opts...)
 	if err != nil {
 		// For a real application, more robust error handling would be needed.
 		log.Fatalf("Failed to create DefraDB node: %v", err)
@@ -98,185 +252,146 @@ func main() {
 	if err != nil {
 		log.Fatalf("Failed to start DefraDB node: %v", err)
 	}
+	span.End()
+
+	// We define a schema for each collection of our knowledge base and load its
+	// documents from a local JSONL file. See addSchema and loadDocuments for
+	// how the `@embedding` directive turns each document into a vector.
+	// Collections that already exist in a persistent store were (at least
+	// partially) loaded by a previous run. Documents that are already stored
+	// are skipped, so an interrupted load resumes where it left off.
+	var stored []string
+	for _, collection := range collections {
+		if collectionExists(ctx, db, collection) {
+			log.Printf("Collection '%s' already exists in the store, resuming load.\n", collection)
+			stored = append(stored, collection)
+		} else {
+			addSchema(ctx, db, collection)
+		}
+		loadDocuments(ctx, db, collection, dataFiles(collection), ingestFilter)
+	}
+	log.Println("Finished loading data into DefraDB.")
 
-	// We define a schema for our data. A schema in DefraDB is similar to a table
-	// definition in a traditional database.
-	// The key part for RAG is the `@embedding` directive.
-	// - `text_v: [Float32!]`: This defines a field to store the vector embedding.
-	// - `@embedding(...)`: This directive tells DefraDB to automatically generate
-	//   an embedding for this field.
-	// - `fields: ["text"]`: Specifies that the embedding should be generated from
-	//   the content of the "text" field.
-	// - `provider: "ollama"`: The embedding provider to use.
-	// - `model: "nomic-embed-text"`: The specific model to use for generating embeddings.
-	log.Println("Adding 'Wiki' collection schema to DefraDB...")
-	_, err = db.DB.AddSchema(ctx, `type Wiki {
-		text: String
-		category: String
-		text_v: [Float32!] @embedding(fields: ["text"], provider: "ollama", model: "nomic-embed-text")
-	}`)
-	if err != nil {
-		// This might fail if the schema is already added. In a real app, you'd
-		// check for this. For this example, we assume a clean start.
-		log.Fatalf("Failed to add schema: %v", err)
+	// A persistent store outlives the embedding model it was loaded with, so we
+	// make sure the stored documents match the current one.
+	if *storeFlag != "" {
+		checkEmbeddingIndex(ctx, db, collections, stored)
+	}
+	// With -reindex-filter, some stored documents are re-embedded on request,
+	// e.g. to refresh part of the knowledge base after a new version of the
+	// embedding model was pulled under the same name.
+	if reindexFilter.Field != "" {
+		for _, collection := range stored {
+			reindexCollection(ctx, db, collection, reindexFilter)
+		}
 	}
 
-	// We'll load our knowledge base from a local JSONL file. Each line in the
-	// file represents a document (a small Wiki article in this case).
-	f, err := os.Open("wiki.jsonl")
-	if err != nil {
-		log.Fatalf("Failed to open wiki.jsonl. Make sure the file exists. Error: %v", err)
+	// An empty knowledge base can't match any question, and retrieval would
+	// only report that no relevant documents were found, as if the question
+	// were the problem. We tell the two cases apart up front.
+	total := 0
+	for _, collection := range collections {
+		total += countDocuments(ctx, db, collection)
+	}
+	if total == 0 {
+		log.Fatalf("The knowledge base is empty: no documents were loaded into %s. "+
+			"Add JSON lines to the data files (see -collections and -data) and run again.", strings.Join(collections, ", "))
 	}
-	defer f.Close()
 
-	d := json.NewDecoder(f)
-	log.Println("Reading JSON lines from wiki.jsonl and adding to the 'Wiki' collection...")
-	for {
-		var article struct {
-			Text     string `json:"text"`
-			Category string `json:"category"`
-		}
-		err := d.Decode(&article)
-		if err == io.EOF {
-			break // Reached end of file
-		} else if err != nil {
-			log.Fatalf("Failed to decode JSON line: %v", err)
-		}
+	if *memoryFlag && !collectionExists(ctx, db, chatTurnCollection) {
+		addChatTurnSchema(ctx, db)
+	}
+	if *interactiveFlag {
+		runChat(ctx, db, collections)
+		return
+	}
+	if *evalFlag != "" {
+		runEval(ctx, db, collections, *evalFlag)
+		return
+	}
 
-		// The 'nomic-embed-text' model performs better when a specific prefix is
-		// added to differentiate between documents for storage ("search_document")
-		// and queries for retrieval ("search_query"). This is a model-specific
-		// requirement and not needed for all embedding models.
-		// We add the prefix here before storing the document.
-		contentWithPrefix := "search_document: " + article.Text
-
-		// We use a GraphQL mutation to create a new document in our 'Wiki' collection.
-		// The `input` argument for a `create` mutation is a document (can also be a list of documents).
-		// When this mutation is executed, DefraDB will:
-		// 1. Take the value of `text`.
-		// 2. Send it to the configured Ollama model (`nomic-embed-text`).
-		// 3. Store the resulting vector embedding in the `text_v` field.
-		//
-		// Note that we could also generate the embedding manually and assign it to `text_v`.
-		createResult := db.DB.ExecRequest(
-			ctx,
-			`mutation CreateWiki($input: [WikiMutationInputArg!]!) {
-				create_Wiki(input: $input) {
-					_docID
-				}
-			}`,
-			client.WithVariables(map[string]any{
-				// Since we are creating one document at a time, we provide
-				// a single document object.
-				"input": map[string]any{
-					"text":     contentWithPrefix,
-					"category": article.Category,
-				},
-			}),
-		)
-		if len(createResult.GQL.Errors) > 0 {
-			// Log all errors for debugging.
-			for _, gqlErr := range createResult.GQL.Errors {
-				log.Printf("GraphQL error on create: %v\n", gqlErr)
-			}
-			log.Fatalf("Failed to create document in DefraDB.")
+	// With -tools, the LLM searches the knowledge base itself, through
+	// function calling, instead of steps 3 and 4. See askWithTools.
+	if *toolsFlag {
+		banner("Asking the LLM with a knowledge base search tool (agentic RAG)")
+		if reply, ok := askWithTools(ctx, db, collections, question); ok {
+			printReply(reply)
+			return
 		}
+		log.Println("Falling back to classic RAG.")
 	}
-	log.Println("Finished loading data into DefraDB.")
 
 	// --- Step 3: Perform Similarity Search to Retrieve Context ---
-	log.Println("================================================================================")
-	log.Println("Retrieving relevant documents from DefraDB")
-	log.Println("================================================================================")
+	banner("Retrieving relevant documents from DefraDB")
 	start := time.Now()
-
-	// As mentioned before, the 'nomic-embed-text' model requires a specific
-	// prefix for queries.
-	queryWithPrefix := "search_query: " + question
-
-	// We need to manually create an embedding for our query. We use the same
-	// model and provider that we configured in the DefraDB schema.
-	//
-	// Note that automatically generating the query embedding is on the development roadmap.
-	log.Println("Creating embedding for the query...")
-	openAIClient := openai.NewClientWithConfig(openai.ClientConfig{
-		BaseURL:    ollamaBaseURL,
-		HTTPClient: http.DefaultClient,
-	})
-	embeddingResp, err := openAIClient.CreateEmbeddings(ctx, openai.EmbeddingRequest{
-		Input: []string{queryWithPrefix},
-		Model: embeddingModel,
-	})
+	queryVector, err := embedQuery(ctx, question)
 	if err != nil {
-		log.Fatalf("Failed to create query embedding: %v", err)
-	}
-
-	// Now we execute a GraphQL query to find the most relevant documents.
-	// - `_similarity`: This is a special DefraDB operator that calculates the
-	//   cosine similarity between a document's vector field (`text_v`) and a
-	//   provided vector (`$queryVector`).
-	// - `sim: _similarity(...)`: We alias the result of the similarity calculation
-	//   to a field named `sim`.
-	// - `order: {_alias: {sim: DESC}}`: We order the results by the similarity
-	//   score in descending order, so the most relevant documents come first.
-	// - `limit: 2`: We ask for the top 2 most similar documents.
-	// - `filter: {_alias: {sim: {_gt: 0.63}}}`: We filter out results with a
-	//   similarity score below a certain threshold to ensure relevance. This
-	//   threshold may need tuning based on your data and use case.
-	log.Println("Querying DefraDB for similar documents...")
-	queryResult := db.DB.ExecRequest(
-		ctx,
-		`query Search($queryVector: [Float32!]!) {
-			Wiki(
-				filter: {_alias: {sim: {_gt: 0.63}}},
-				limit: 2,
-				order: {_alias: {sim: DESC}}
-			) {
-				text
-				sim: _similarity(text_v: {vector: $queryVector})
-			}
-		}`,
-		client.WithVariables(map[string]any{
-			"queryVector": embeddingResp.Data[0].Embedding,
-		}),
-	)
-	if len(queryResult.GQL.Errors) > 0 {
-		for _, gqlErr := range queryResult.GQL.Errors {
-			log.Printf("GraphQL error on query: %v\n", gqlErr)
+		log.Printf("Failed to create query embedding: %v\n", err)
+		log.Printf("Make sure Ollama is running at %s.\n", ollamaAPIURL(""))
+		// The answer record ends the JSON output even without an answer, so
+		// that readers always get one, and can tell why there is none.
+		if *jsonFlag {
+			printJSON(answerRecord{Error: errEmbeddingUnavailable.Error()})
 		}
-		log.Fatalf("Failed to query documents from DefraDB.")
+		exitCode = exitEmbeddingUnavailable
+		return
+	}
+	docs, err := retrieve(ctx, db, collections, queryVector)
+	if err != nil {
+		log.Fatalf("Failed to search the knowledge base: %v", err)
 	}
-
 	log.Printf("Search (incl. query embedding) took %s\n", time.Since(start))
 
-	resultData, ok := queryResult.GQL.Data.(map[string]any)["Wiki"].([]map[string]any)
-	if !ok || len(resultData) == 0 {
-		log.Println("No relevant documents found in the knowledge base.")
+	// Print the retrieved documents and their similarity to the question.
+	logDocs(docs)
+	if *jsonFlag {
+		for _, doc := range docs {
+			printJSON(newDocRecord(doc))
+		}
+	}
+	lowConfidence := !confident(docs)
+
+	// With -fallback, a question the knowledge base can't answer is answered
+	// by the LLM alone, like in step 1, with a disclaimer. The system prompt
+	// template already handles the case without contexts.
+	if (len(docs) == 0 || lowConfidence) && *fallbackFlag {
+		banner("Asking the LLM without retrieved knowledge (fallback)")
+		reply := fallbackDisclaimer + askLLM(ctx, nil, nil, question, nil)
+		if !*demoFlag {
+			printAnswer(reply)
+			return
+		}
+		log.Printf("Reply without retrieved knowledge: \"%s\"\n", reply)
 		return
 	}
-
-	// Print the retrieved documents and their similarity to the question.
-	log.Println("Found relevant documents:")
-	var contexts []string
-	for i, res := range resultData {
-		// Remember to remove the "search_document: " prefix we added earlier
-		// before passing the text to the LLM.
-		content := strings.TrimPrefix(res["text"].(string), "search_document: ")
-		log.Printf(" - Document %d (similarity: %.4f): \"%s...\"\n", i+1, res["sim"], content[:100])
-		contexts = append(contexts, content)
+	if lowConfidence {
+		if !*demoFlag {
+			printAnswer(insufficientContextAnswer)
+		} else {
+			log.Println(insufficientContextAnswer)
+		}
+		exitCode = exitInsufficientContext
+		return
 	}
+	if len(docs) == 0 {
+		// There is nothing to answer from. The answer record still ends the
+		// JSON output, so that readers always get one.
+		if *jsonFlag {
+			printJSON(answerRecord{NoResults: true})
+		}
+		return
+	}
+	contexts := formatContexts(docs)
 
 	// --- Step 4: Ask the LLM with RAG ---
 	// Now we ask the same question again, but this time we provide the retrieved
 	// documents as context to the LLM.
-	log.Println("================================================================================")
-	log.Println("Asking the LLM with retrieved knowledge (with RAG)")
-	log.Println("================================================================================")
+	banner("Asking the LLM with retrieved knowledge (with RAG)")
 	log.Println("Asking LLM with augmented question...")
-	reply = askLLM(ctx, contexts, question)
-	log.Printf("Reply after augmenting the question with knowledge: \"%s\"\n", reply)
+	reply := askLLM(ctx, contexts, nil, question, nil)
+	printReply(reply)
 
-	/* Output (can differ slightly on each run):
+	/* Output (can differ slightly on each run, unless using -seed):
 	2024/08/02 14:30:10 Warming up Ollama...
 	2024/08/02 14:30:12 ================================================================================
 	2024/08/02 14:30:12 Attempt 1: Asking the LLM without providing any external knowledge (no RAG)
@@ -288,90 +403,78 @@ func main() {
 	*/
 }
 
-// systemPromptTpl is a Go template for generating the system prompt.
-// A system prompt is a powerful way to guide the LLM's behavior, setting its
-// persona, instructions, and constraints.
-//
-// Prompt engineering is a critical part of building a successful RAG system.
-// The quality of the prompt can significantly impact the quality of the answer.
+// banner logs the title of a step of the demo between two separator lines.
+// Outside of the demo (-demo=false), the steps are not narrated.
+func banner(title string) {
+	if !*demoFlag {
+		return
+	}
+	log.Println("================================================================================")
+	log.Println(title)
+	log.Println("================================================================================")
+}
+
+// printReply prints the final answer to the question.
+func printReply(reply string) {
+	if !*demoFlag {
+		printAnswer(reply)
+		return
+	}
+	log.Printf("Reply after augmenting the question with knowledge: \"%s\"\n", reply)
+}
+
+// printAnswer prints the answer outside of the demo. Only the answer goes to
+// stdout, so that it can be used by other programs. Everything else is logged
+// to stderr.
+func printAnswer(answer string) {
+	if *jsonFlag {
+		printJSON(answerRecord{Answer: answer})
+		return
+	}
+	fmt.Println(answer)
+}
+
+// docRecord is the JSON line printed for a retrieved document with -json.
+type docRecord struct {
+	Collection string `json:"collection"`
+	Source     string `json:"source,omitempty"`
+	Category   string `json:"category,omitempty"`
+	Title      string `json:"title,omitempty"`
+	Text       string `json:"text"`
+	// Score is the cosine similarity between the document and the question.
+	Score float64 `json:"score"`
+}
+
+// newDocRecord returns the JSON line of a retrieved document.
+func newDocRecord(doc retrievedDoc) docRecord {
+	return docRecord{
+		Collection: doc.Collection,
+		Source:     doc.Source,
+		Category:   doc.Category,
+		Title:      doc.Title,
+		Text:       doc.Text,
+		Score:      doc.Similarity,
+	}
+}
+
+// answerRecord is the JSON line printed for the answer with -json. It always
+// comes last, even when no document was retrieved and the answer is empty.
+type answerRecord struct {
+	Answer string `json:"answer"`
+	// NoResults is set when no document was retrieved, so the LLM wasn't asked.
+	NoResults bool `json:"no_results,omitempty"`
+	// Error is set when the question couldn't be answered because of an error.
+	Error string `json:"error,omitempty"`
+}
+
+// printJSON prints v to stdout as a single line of JSON.
 //
-// In this prompt:
-//   - We tell the LLM it's a helpful assistant.
-//   - We instruct it to be concise and unbiased.
-//   - When context is provided (the `if .` block), we strictly instruct it to
-//     answer *only* based on the provided search results. This helps prevent the
-//     LLM from "hallucinating" or using its own (potentially outdated or incorrect)
-//     internal knowledge.
-//   - The `<context>` block is a common convention to clearly separate the
-//     retrieved information from the user's question.
-var systemPromptTpl = template.Must(template.New("system_prompt").Parse(`
-You are a helpful assistant with access to a knowlege base, tasked with answering questions about the world and its history, people, places and other things.
-
-Answer the question in a very concise manner. Use an unbiased and journalistic tone. Do not repeat text. Don't make anything up. If you are not sure about something, just say that you don't know.
-{{- /* Stop here if no context is provided. The rest below is for handling contexts. */ -}}
-{{- if . -}}
-Answer the question solely based on the provided search results from the knowledge base. If the search results from the knowledge base are not relevant to the question at hand, just say that you don't know. Don't make anything up.
-
-Anything between the following 'context' XML blocks is retrieved from the knowledge base, not part of the conversation with the user. The bullet points are ordered by relevance, so the first one is the most relevant.
-
-<context>
-    {{- if . -}}
-    {{- range $context := .}}
-    - {{.}}{{end}}
-    {{- end}}
-</context>
-{{- end -}}
-
-Don't mention the knowledge base, context or search results in your answer.
-`))
-
-// askLLM sends a request to the LLM with an optional context and a question.
-func askLLM(ctx context.Context, contexts []string, question string) string {
-	// We can use the standard OpenAI client because Ollama exposes an
-	// OpenAI-compatible API. We just need to point the client to the local
-	// Ollama server URL.
-	openAIClient := openai.NewClientWithConfig(openai.ClientConfig{
-		BaseURL:    ollamaBaseURL,
-		HTTPClient: http.DefaultClient,
-	})
-
-	// We use the template to generate the final system prompt, injecting the
-	// retrieved contexts if they exist.
-	sb := &strings.Builder{}
-	err := systemPromptTpl.Execute(sb, contexts)
-	if err != nil {
-		// This should not happen with a valid template.
-		log.Fatalf("Failed to execute system prompt template: %v", err)
-	}
-
-	openAIClient.CreateEmbeddings(ctx, openai.EmbeddingRequest{
-		Input: []string{question},
-		Model: embeddingModel,
-	})
-
-	// We construct the chat messages. The conversation consists of:
-	// 1. The system prompt (our instructions to the LLM).
-	// 2. The user's question.
-	messages := []openai.ChatCompletionMessage{
-		{
-			Role:    openai.ChatMessageRoleSystem,
-			Content: sb.String(),
-		}, {
-			Role:    openai.ChatMessageRoleUser,
-			Content: "Question: " + question,
-		},
-	}
-
-	res, err := openAIClient.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
-		Model:    llmModel,
-		Messages: messages,
-	})
+// With -json, the output is newline-delimited JSON: a line for each retrieved
+// document, most relevant first, then a line for the answer. A program can
+// read it line by line, and tell the records apart by their fields.
+func printJSON(v any) {
+	err := json.NewEncoder(os.Stdout).Encode(v)
 	if err != nil {
-		log.Fatalf("Ollama chat completion failed: %v", err)
+		log.Fatalf("Failed to write JSON output: %v", err)
 	}
-
-	// The response from the LLM might have leading/trailing whitespace,
-	// so we trim it for a cleaner output.
-	reply := res.Choices[0].Message.Content
-	return strings.TrimSpace(reply)
 }
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/sashabaranov/go-openai"       // OpenAI client, compatible with Ollama's API
+	"github.com/sourcenetwork/defradb/client" // DefraDB client
+	"github.com/sourcenetwork/defradb/node"   // DefraDB node
+)
+
+// chatTurnCollection is the collection used to remember the conversation.
+const chatTurnCollection = "ChatTurn"
+
+// summaryPrompt asks the LLM to summarize the earlier turns of a conversation.
+// See compressHistory.
+const summaryPrompt = `Summarize the following conversation in a few sentences. Keep the facts and names a follow-up question could refer to, and leave out everything else.
+
+%s`
+
+// addChatTurnSchema adds the collection storing past conversation turns.
+//
+// A turn keeps the question and answer as separate fields, plus a `text` field
+// combining both that is embedded exactly like a knowledge base document, and
+// the `date` it was asked at. This means past turns can be searched and ranked
+// with the same similarity query as the knowledge base itself.
+func addChatTurnSchema(ctx context.Context, db *node.Node) {
+	log.Printf("Adding '%s' collection schema to DefraDB...\n", chatTurnCollection)
+	_, err := db.DB.AddSchema(ctx, fmt.Sprintf(`type %[1]s {
+		question: String
+		answer: String
+		date: DateTime
+		text: String
+		text_v: [Float32!] @embedding(fields: ["text"], provider: "ollama", model: "%[2]s")
+	}`, chatTurnCollection, embeddingModel))
+	if err != nil {
+		log.Fatalf("Failed to add schema: %v", err)
+	}
+}
+
+// saveTurn stores a question and the answer given to it.
+func saveTurn(ctx context.Context, db *node.Node, question string, answer string) {
+	input := map[string]any{
+		"question": question,
+		"answer":   answer,
+		"date":     time.Now().UTC().Format(time.RFC3339),
+		// Like knowledge base documents, the embedded text gets the document
+		// prefix expected by the embedding model.
+		"text": *docPrefixFlag + formatTurn(question, answer),
+	}
+	if *embedDimensionsFlag > 0 {
+		embedMissing(ctx, []map[string]any{input})
+	}
+
+	createResult := execRequest(
+		ctx,
+		db,
+		fmt.Sprintf(`mutation Create($input: [%[1]sMutationInputArg!]!) {
+			create_%[1]s(input: $input) {
+				_docID
+			}
+		}`, chatTurnCollection),
+		client.WithVariables(map[string]any{"input": input}),
+	)
+	if len(createResult.GQL.Errors) > 0 {
+		for _, gqlErr := range createResult.GQL.Errors {
+			log.Printf("GraphQL error on create: %v\n", gqlErr)
+		}
+		log.Fatalf("Failed to save conversation turn in DefraDB.")
+	}
+}
+
+// chatTurnFields are the fields selected from past turns when recalling them.
+// A turn has none of the labels of a knowledge base document.
+var chatTurnFields = []string{"text", "date"}
+
+// recallTurns returns the past turns most similar to the query vector.
+func recallTurns(ctx context.Context, db *node.Node, queryVector []float32) []retrievedDoc {
+	log.Println("Querying DefraDB for similar past turns...")
+	checkCollectionDimension(ctx, db, chatTurnCollection)
+	docs, err := queryCollection(ctx, db, chatTurnCollection, chatTurnFields, queryVector, maxResults)
+	if err != nil {
+		// The question can still be answered from the knowledge base alone.
+		log.Printf("Failed to search the past turns: %v\n", err)
+		return nil
+	}
+	if *recencyWeightFlag > 0 {
+		scoreByRecency(docs, *recencyWeightFlag)
+	}
+	return docs
+}
+
+// compressHistory replaces all but the last turn of a conversation with a
+// summary written by the LLM.
+//
+// The conversation is sent to the LLM with every question, and would
+// eventually fill its whole context window. Summarizing the older turns keeps
+// what matters to follow-up questions in a fraction of the tokens. The summary
+// itself is summarized again with the next turns when the conversation grows
+// past the limit once more.
+func compressHistory(ctx context.Context, history []openai.ChatCompletionMessage) []openai.ChatCompletionMessage {
+	// The last turn is a question and its answer.
+	older, recent := history[:len(history)-2], history[len(history)-2:]
+	if len(older) == 0 {
+		return history
+	}
+	log.Printf("Summarizing %d earlier messages of the conversation...\n", len(older))
+
+	var sb strings.Builder
+	for _, m := range older {
+		sb.WriteString(m.Role + ": " + m.Content + "\n")
+	}
+	reply, err := textGenerator.Generate(ctx, "", []openai.ChatCompletionMessage{
+		{
+			Role:    openai.ChatMessageRoleUser,
+			Content: fmt.Sprintf(summaryPrompt, sb.String()),
+		},
+	})
+	if err != nil {
+		log.Fatalf("Ollama chat completion failed: %v", err)
+	}
+
+	summary := openai.ChatCompletionMessage{
+		Role:    openai.ChatMessageRoleSystem,
+		Content: "Summary of the earlier conversation: " + strings.TrimSpace(reply),
+	}
+	return append([]openai.ChatCompletionMessage{summary}, recent...)
+}
+
+// formatTurn renders a conversation turn as a single piece of text.
+func formatTurn(question string, answer string) string {
+	return "Question: " + question + "\nAnswer: " + answer
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestRecallTurns(t *testing.T) {
+	ctx := context.Background()
+	db := newTestNode(t)
+
+	// With -embed-dimensions, saveTurn embeds the turn itself, with the fake
+	// embedder, so DefraDB doesn't need Ollama either.
+	previousDimensions := *embedDimensionsFlag
+	*embedDimensionsFlag = 2
+	t.Cleanup(func() {
+		*embedDimensionsFlag = previousDimensions
+		delete(checkedCollections, chatTurnCollection)
+	})
+	turn := *docPrefixFlag + formatTurn("Who founded Monarch?", "Frank Hardy.")
+	useEmbedder(t, &fakeEmbedder{vectors: map[string][]float32{turn: {0.6, 0.8}}})
+
+	addChatTurnSchema(ctx, db)
+	saveTurn(ctx, db, "Who founded Monarch?", "Frank Hardy.")
+
+	docs := recallTurns(ctx, db, []float32{0.6, 0.8})
+	if len(docs) != 1 {
+		t.Fatalf("recalled %d turns, want the saved one", len(docs))
+	}
+	if want := formatTurn("Who founded Monarch?", "Frank Hardy."); docs[0].Text != want {
+		t.Errorf("recalled %q, want %q", docs[0].Text, want)
+	}
+	if docs[0].Date.IsZero() {
+		t.Error("the recalled turn has no date")
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ollamaAPIURL returns the URL of an endpoint of Ollama's native API. Listing
+// and pulling models isn't part of the OpenAI-compatible API, which lives
+// under `/v1`.
+func ollamaAPIURL(path string) string {
+	return strings.TrimSuffix(ollamaBaseURL, "/v1") + path
+}
+
+// checkModels makes sure the chat and embedding models are available in
+// Ollama, and pulls the missing ones if autoPull is set. The chat model is only
+// checked if it is served by Ollama too (see chatOnOllama).
+//
+// Without this check, a missing model only shows up as a confusing error on
+// the first completion or embedding request.
+func checkModels(ctx context.Context, autoPull bool) {
+	installed := listModels(ctx)
+	var missing []string
+	models := []string{embeddingModel}
+	if chatOnOllama() {
+		models = append(models, *llmModelFlag)
+	}
+	for _, model := range models {
+		// Models without a tag are stored with the `latest` tag.
+		if !installed[model] && !installed[model+":latest"] {
+			missing = append(missing, model)
+		}
+	}
+	if len(missing) == 0 {
+		return
+	}
+
+	if !autoPull {
+		var cmds []string
+		for _, model := range missing {
+			cmds = append(cmds, "ollama pull "+model)
+		}
+		log.Fatalf("Missing Ollama models: %s. Pull them with:\n\n  %s\n\nor re-run with -auto-pull.",
+			strings.Join(missing, ", "), strings.Join(cmds, "\n  "))
+	}
+	for _, model := range missing {
+		pullModel(ctx, model)
+	}
+}
+
+// listModels returns the names of the models available in Ollama.
+func listModels(ctx context.Context) map[string]bool {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ollamaAPIURL("/api/tags"), nil)
+	if err != nil {
+		log.Fatalf("Failed to create request: %v", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.Fatalf("Failed to reach Ollama at %s. Make sure it is running. Error: %v", ollamaAPIURL(""), err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		log.Fatalf("Failed to list Ollama models: %s", resp.Status)
+	}
+
+	var tags struct {
+		Models []struct {
+			Name string `json:"name"`
+		} `json:"models"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&tags)
+	if err != nil {
+		log.Fatalf("Failed to decode Ollama models: %v", err)
+	}
+	installed := map[string]bool{}
+	for _, model := range tags.Models {
+		installed[model.Name] = true
+	}
+	return installed
+}
+
+// pullModel downloads a model into Ollama, waiting until it is done.
+func pullModel(ctx context.Context, model string) {
+	log.Printf("Pulling %s into Ollama, this can take a while...\n", model)
+	start := time.Now()
+	body := strings.NewReader(fmt.Sprintf(`{"model": %q, "stream": false}`, model))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ollamaAPIURL("/api/pull"), body)
+	if err != nil {
+		log.Fatalf("Failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.Fatalf("Failed to pull %s: %v", model, err)
+	}
+	defer resp.Body.Close()
+
+	var status struct {
+		Status string `json:"status"`
+		Error  string `json:"error"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&status)
+	if err != nil {
+		log.Fatalf("Failed to decode pull response for %s: %v", model, err)
+	}
+	if resp.StatusCode != http.StatusOK || status.Error != "" {
+		log.Fatalf("Failed to pull %s: %s %s", model, resp.Status, status.Error)
+	}
+	log.Printf("Pulled %s in %s\n", model, time.Since(start).Round(time.Second))
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/sashabaranov/go-openai" // OpenAI client, compatible with Ollama's API
+)
+
+// The chat LLM and the embedding model are reached through the same
+// OpenAI-compatible API, but not necessarily at the same place.
+//
+// Embeddings always come from the local Ollama: DefraDB creates the document
+// embeddings itself with its "ollama" provider (see addSchema), and the query
+// embeddings must come from the same model to be comparable. The chat LLM can
+// be any OpenAI-compatible service instead, e.g. OpenAI itself with
+// `-llm-url https://api.openai.com/v1 -llm-model gpt-4o-mini`, which requires
+// an API key.
+
+// newChatClient returns a client for the chat LLM at -llm-url, authenticated
+// with -api-key if it is set. Ollama ignores the key.
+func newChatClient() *openai.Client {
+	config := openai.DefaultConfig(*apiKeyFlag)
+	config.BaseURL = *llmURLFlag
+	config.HTTPClient = httpClient
+	return openai.NewClientWithConfig(config)
+}
+
+// newEmbeddingClient returns a client for the embedding model in the local
+// Ollama, which needs no API key.
+func newEmbeddingClient() *openai.Client {
+	return openai.NewClientWithConfig(openai.ClientConfig{
+		BaseURL:    ollamaBaseURL,
+		HTTPClient: httpClient,
+	})
+}
+
+// chatOnOllama reports whether the chat LLM is served by the local Ollama,
+// rather than by a hosted provider.
+func chatOnOllama() bool {
+	return *llmURLFlag == ollamaBaseURL
+}
+
+// Embedder creates the embeddings of texts, one vector per text, in order.
+//
+// Every embedding created by the example itself (queries, and documents with
+// -embed-dimensions, -dedup or -reindex) goes through textEmbedder. Another
+// provider, or a fake one for testing, only has to implement this interface.
+// Keep in mind that DefraDB creates the other document embeddings with the
+// `@embedding` directive, and that both must come from the same model.
+type Embedder interface {
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+}
+
+// openAIEmbedder creates embeddings through an OpenAI-compatible API.
+type openAIEmbedder struct {
+	client *openai.Client
+}
+
+// Embed implements Embedder.
+func (e openAIEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	embeddingResp, err := e.client.CreateEmbeddings(ctx, embeddingRequest(texts))
+	if err != nil {
+		return nil, err
+	}
+	// Callers index the vectors by text, so a short response would make them
+	// panic.
+	if len(embeddingResp.Data) != len(texts) {
+		return nil, fmt.Errorf("got %d embeddings for %d texts", len(embeddingResp.Data), len(texts))
+	}
+	vectors := make([][]float32, 0, len(embeddingResp.Data))
+	for _, data := range embeddingResp.Data {
+		vectors = append(vectors, data.Embedding)
+	}
+	return vectors, nil
+}
+
+// textEmbedder is the Embedder used by the example: the embedding model in the
+// local Ollama.
+var textEmbedder Embedder = openAIEmbedder{client: newEmbeddingClient()}
+
+// Generator generates the replies of the chat LLM.
+//
+// system is the system prompt, and messages the conversation that follows it,
+// ending with the user's question. A system prompt that is empty is left out.
+// GenerateStream is like Generate, but calls onToken with each piece of the
+// reply as soon as it is generated, which is how chat applications show the
+// answer while it is being written. GenerateWithTools offers tools to the LLM
+// (see askWithTools), and returns its whole reply message, which holds either
+// an answer or tool calls.
+//
+// Every chat request of the example goes through textGenerator, so another
+// backend, or a fake one for testing, only has to implement this interface.
+type Generator interface {
+	Generate(ctx context.Context, system string, messages []openai.ChatCompletionMessage) (string, error)
+	GenerateStream(ctx context.Context, system string, messages []openai.ChatCompletionMessage, onToken func(string)) (string, error)
+	GenerateWithTools(ctx context.Context, system string, messages []openai.ChatCompletionMessage, tools []openai.Tool) (openai.ChatCompletionMessage, error)
+}
+
+// errNoChoices is returned when the chat LLM replies without any message, which
+// some providers do, e.g. when a content filter removed the reply.
+var errNoChoices = errors.New("the LLM returned no reply")
+
+// openAIGenerator generates replies through an OpenAI-compatible API, with the
+// sampling options of the command line (see chatRequest).
+//
+// The client is created for every request rather than once, because it
+// depends on -llm-url and -api-key, which aren't parsed yet when textGenerator
+// is initialized.
+type openAIGenerator struct{}
+
+// Generate implements Generator.
+func (openAIGenerator) Generate(ctx context.Context, system string, messages []openai.ChatCompletionMessage) (string, error) {
+	res, err := newChatClient().CreateChatCompletion(ctx, chatRequest(withSystemPrompt(system, messages)))
+	if err != nil {
+		return "", err
+	}
+	if len(res.Choices) == 0 {
+		return "", errNoChoices
+	}
+	return res.Choices[0].Message.Content, nil
+}
+
+// GenerateStream implements Generator.
+func (openAIGenerator) GenerateStream(ctx context.Context, system string, messages []openai.ChatCompletionMessage, onToken func(string)) (string, error) {
+	stream, err := newChatClient().CreateChatCompletionStream(ctx, chatRequest(withSystemPrompt(system, messages)))
+	if err != nil {
+		return "", err
+	}
+	defer stream.Close()
+
+	var reply strings.Builder
+	for {
+		res, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return reply.String(), nil
+		}
+		if err != nil {
+			return "", err
+		}
+		if len(res.Choices) == 0 {
+			continue
+		}
+		token := res.Choices[0].Delta.Content
+		reply.WriteString(token)
+		onToken(token)
+	}
+}
+
+// GenerateWithTools implements Generator.
+func (openAIGenerator) GenerateWithTools(ctx context.Context, system string, messages []openai.ChatCompletionMessage, tools []openai.Tool) (openai.ChatCompletionMessage, error) {
+	req := chatRequest(withSystemPrompt(system, messages))
+	req.Tools = tools
+	res, err := newChatClient().CreateChatCompletion(ctx, req)
+	if err != nil {
+		return openai.ChatCompletionMessage{}, err
+	}
+	if len(res.Choices) == 0 {
+		return openai.ChatCompletionMessage{}, errNoChoices
+	}
+	return res.Choices[0].Message, nil
+}
+
+// withSystemPrompt returns the messages preceded by the system prompt, unless
+// it is empty.
+func withSystemPrompt(system string, messages []openai.ChatCompletionMessage) []openai.ChatCompletionMessage {
+	if system == "" {
+		return messages
+	}
+	return append([]openai.ChatCompletionMessage{{
+		Role:    openai.ChatMessageRoleSystem,
+		Content: system,
+	}}, messages...)
+}
+
+// textGenerator is the Generator used by the example: the chat LLM at
+// -llm-url.
+var textGenerator Generator = openAIGenerator{}
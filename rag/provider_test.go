@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sashabaranov/go-openai" // OpenAI client, compatible with Ollama's API
+)
+
+// newFixedReplyServer starts an OpenAI-compatible API replying to every request
+// with the given JSON body, closed at the end of the test.
+func newFixedReplyServer(t *testing.T, body string) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestOpenAIGeneratorNoChoices(t *testing.T) {
+	server := newFixedReplyServer(t, `{"choices": []}`)
+	previous := *llmURLFlag
+	*llmURLFlag = server.URL
+	t.Cleanup(func() { *llmURLFlag = previous })
+	messages := []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: "Hi"}}
+
+	_, err := openAIGenerator{}.Generate(context.Background(), "", messages)
+	if !errors.Is(err, errNoChoices) {
+		t.Errorf("Generate returned %v, want errNoChoices", err)
+	}
+	_, err = openAIGenerator{}.GenerateWithTools(context.Background(), "", messages, nil)
+	if !errors.Is(err, errNoChoices) {
+		t.Errorf("GenerateWithTools returned %v, want errNoChoices", err)
+	}
+}
+
+func TestOpenAIEmbedderMissingData(t *testing.T) {
+	server := newFixedReplyServer(t, `{"data": []}`)
+	e := openAIEmbedder{client: openai.NewClientWithConfig(openai.ClientConfig{
+		BaseURL:    server.URL,
+		HTTPClient: http.DefaultClient,
+	})}
+
+	vectors, err := e.Embed(context.Background(), []string{"a"})
+	if err == nil {
+		t.Errorf("Embed returned %v, want an error", vectors)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// maxRateLimitRetries is the number of times a rate-limited request is retried
+// before giving up.
+const maxRateLimitRetries = 5
+
+// httpClient is the HTTP client of all the requests to the chat and embedding
+// APIs (see provider.go). It throttles them with -rate-limit-rps and retries
+// those that are rate limited, so that a hosted provider's limits slow the
+// example down instead of ending it.
+var httpClient = &http.Client{Transport: &rateLimitTransport{next: http.DefaultTransport}}
+
+// rateLimitTransport is an http.RoundTripper that spaces out requests to at
+// most -rate-limit-rps per second, and retries the requests answered with
+// `429 Too Many Requests`.
+//
+// A retry waits for as long as the `Retry-After` header of the response asks,
+// or, without it, for an exponentially growing delay: 1s, 2s, 4s, and so on.
+type rateLimitTransport struct {
+	next http.RoundTripper
+
+	mu sync.Mutex
+	// nextSlot is the earliest time the next request may be sent.
+	nextSlot time.Time
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		err := t.wait(req)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := t.next.RoundTrip(req)
+		if err != nil || resp.StatusCode != http.StatusTooManyRequests || attempt == maxRateLimitRetries || req.GetBody == nil {
+			return resp, err
+		}
+
+		delay := retryAfter(resp.Header.Get("Retry-After"), attempt)
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		log.Printf("Rate limited by %s, retrying in %s...\n", req.URL.Host, delay)
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(delay):
+		}
+
+		// The body of the previous attempt has been consumed, so the request
+		// is sent again with a fresh copy.
+		req = req.Clone(req.Context())
+		req.Body, err = req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// wait blocks until the request may be sent according to -rate-limit-rps.
+func (t *rateLimitTransport) wait(req *http.Request) error {
+	if *rateLimitRPSFlag <= 0 {
+		return nil
+	}
+	t.mu.Lock()
+	now := time.Now()
+	slot := t.nextSlot
+	if slot.Before(now) {
+		slot = now
+	}
+	t.nextSlot = slot.Add(time.Duration(float64(time.Second) / *rateLimitRPSFlag))
+	t.mu.Unlock()
+
+	select {
+	case <-req.Context().Done():
+		return req.Context().Err()
+	case <-time.After(time.Until(slot)):
+		return nil
+	}
+}
+
+// retryAfter returns how long to wait before retrying a rate-limited request.
+// header is the value of the `Retry-After` header, which is either a number of
+// seconds or an HTTP date. Without a valid one, the delay doubles with every
+// attempt.
+func retryAfter(header string, attempt int) time.Duration {
+	if seconds, err := strconv.Atoi(header); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(header); err == nil {
+		return max(time.Until(t), 0)
+	}
+	return time.Second << attempt
+}
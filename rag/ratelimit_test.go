@@ -0,0 +1,109 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  string
+		attempt int
+		min     time.Duration
+		max     time.Duration
+	}{
+		{name: "seconds", header: "3", min: 3 * time.Second, max: 3 * time.Second},
+		{name: "zero seconds", header: "0", attempt: 2, min: 0, max: 0},
+		{
+			// HTTP dates have a resolution of a second.
+			name:   "HTTP date",
+			header: time.Now().Add(10 * time.Second).UTC().Format(http.TimeFormat),
+			min:    8 * time.Second,
+			max:    10 * time.Second,
+		},
+		{name: "HTTP date in the past", header: time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat), min: 0, max: 0},
+		{name: "no header", header: "", attempt: 0, min: time.Second, max: time.Second},
+		{name: "no header, third attempt", header: "", attempt: 2, min: 4 * time.Second, max: 4 * time.Second},
+		{name: "negative seconds", header: "-1", attempt: 1, min: 2 * time.Second, max: 2 * time.Second},
+		{name: "invalid", header: "soon", attempt: 1, min: 2 * time.Second, max: 2 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := retryAfter(tt.header, tt.attempt)
+			if got < tt.min || got > tt.max {
+				t.Errorf("retryAfter(%q, %d) = %s, want between %s and %s", tt.header, tt.attempt, got, tt.min, tt.max)
+			}
+		})
+	}
+}
+
+func TestRateLimitTransport(t *testing.T) {
+	tests := []struct {
+		name       string
+		retryAfter string
+		// limited is the number of requests answered with 429 before the
+		// server accepts one.
+		limited      int
+		wantStatus   int
+		wantRequests int
+	}{
+		{name: "not limited", limited: 0, wantStatus: http.StatusOK, wantRequests: 1},
+		{name: "retried after seconds", retryAfter: "0", limited: 1, wantStatus: http.StatusOK, wantRequests: 2},
+		{
+			name:         "retried after an HTTP date",
+			retryAfter:   time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat),
+			limited:      2,
+			wantStatus:   http.StatusOK,
+			wantRequests: 3,
+		},
+		{
+			// After maxRateLimitRetries retries, the last 429 is returned.
+			name:         "too many retries",
+			retryAfter:   "0",
+			limited:      maxRateLimitRetries + 1,
+			wantStatus:   http.StatusTooManyRequests,
+			wantRequests: maxRateLimitRetries + 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var bodies []string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				bodies = append(bodies, string(body))
+				if len(bodies) <= tt.limited {
+					w.Header().Set("Retry-After", tt.retryAfter)
+					w.WriteHeader(http.StatusTooManyRequests)
+					return
+				}
+				w.WriteHeader(http.StatusOK)
+			}))
+			defer server.Close()
+
+			client := &http.Client{Transport: &rateLimitTransport{next: http.DefaultTransport}}
+			resp, err := client.Post(server.URL, "application/json", strings.NewReader(`{"input":"a"}`))
+			if err != nil {
+				t.Fatalf("request failed: %v", err)
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("got status %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if len(bodies) != tt.wantRequests {
+				t.Errorf("the server got %d requests, want %d", len(bodies), tt.wantRequests)
+			}
+			// Every retry sends the request body again.
+			for i, body := range bodies {
+				if body != `{"input":"a"}` {
+					t.Errorf("request %d had the body %q", i+1, body)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,475 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sourcenetwork/defradb/client"           // DefraDB client
+	"github.com/sourcenetwork/defradb/node"             // DefraDB node
+	"github.com/sourcenetwork/examples/internal/shared" // Helpers shared by the examples
+	"go.opentelemetry.io/otel/attribute"                // Span attributes
+	"go.opentelemetry.io/otel/trace"                    // Tracing API
+	"golang.org/x/sync/errgroup"                        // Goroutines with error handling
+)
+
+const (
+	// maxResults is the number of documents retrieved for a question, across all
+	// collections of the knowledge base.
+	maxResults = 2
+
+	// rerankCandidates is how many times more documents than maxResults are
+	// fetched from each collection when ranking by recency or with MMR. Both
+	// can promote a document that isn't among the most similar ones, so they
+	// need a larger pool to choose from.
+	rerankCandidates = 5
+
+	// insufficientContextAnswer is the answer given without asking the LLM
+	// when the retrieved documents are not similar enough to the question.
+	// See confident.
+	insufficientContextAnswer = "I don't have enough context to answer this question."
+
+	// fallbackDisclaimer is prepended to answers given without any retrieved
+	// document, with -fallback.
+	fallbackDisclaimer = "(Not found in the knowledge base. This answer comes from the model's general knowledge and may be inaccurate.) "
+)
+
+// documentFields are the fields selected, besides the similarity, from the
+// documents of the knowledge base (see addSchema).
+var documentFields = []string{"text", "category", "title", "date", "source"}
+
+// checkedCollections holds the collections whose stored embeddings have been
+// checked against the query embedding dimension.
+var checkedCollections = map[string]bool{}
+
+// searchCollection searches a single collection of the knowledge base. It is
+// queryCollection, replaced by a fake in tests that don't run a DefraDB node.
+var searchCollection = queryCollection
+
+// retrievedDoc is a document retrieved from the knowledge base.
+type retrievedDoc struct {
+	// Collection is the collection the document was found in.
+	Collection string
+	// Source is the file the document was loaded from, if known.
+	Source string
+	// Category and Title describe the document, if it has them.
+	Category string
+	Title    string
+	// Text is the document content, without the embedding model prefix.
+	Text string
+	// Similarity is the cosine similarity between the document and the question.
+	Similarity float64
+	// Date is the date of the document, if it has one.
+	Date time.Time
+	// Score is the value documents are ranked by. It is the similarity, unless
+	// ranking by recency.
+	Score float64
+	// Vector is the embedding of the document. It is only fetched when
+	// selecting documents with MMR.
+	Vector []float32
+}
+
+// errEmbeddingUnavailable is returned by embedQuery when the embedding model
+// can't be reached.
+var errEmbeddingUnavailable = errors.New("embedding service unavailable")
+
+// embedQuery creates the embedding vector used to search the knowledge base
+// for the given question.
+//
+// Ollama may be up during ingestion but down by the time a question is asked.
+// Rather than exiting, embedQuery then returns an error wrapping
+// errEmbeddingUnavailable, so that the caller can tell it apart from a
+// question without matches, and decide whether to go on.
+func embedQuery(ctx context.Context, question string) ([]float32, error) {
+	// As mentioned before, the 'nomic-embed-text' model requires a specific
+	// prefix for queries (see -query-prefix).
+	queryWithPrefix := *queryPrefixFlag + question
+
+	// We need to manually create an embedding for our query. We use the same
+	// model and provider that we configured in the DefraDB schema.
+	//
+	// Note that automatically generating the query embedding is on the development roadmap.
+	log.Println("Creating embedding for the query...")
+	ctx, span := tracer.Start(ctx, "ollama.embed.query", trace.WithAttributes(
+		attribute.String("ollama.model", embeddingModel),
+	))
+	vectors, err := textEmbedder.Embed(ctx, []string{queryWithPrefix})
+	endSpan(span, err)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errEmbeddingUnavailable, err)
+	}
+	queryVector := vectors[0]
+	checkRequestedDimension(len(queryVector))
+	checkDimension("the query embedding", len(queryVector))
+	return queryVector, nil
+}
+
+// retrieve returns the documents of the knowledge base that are most similar
+// to the query vector, most similar first.
+//
+// The collections are searched concurrently, so that a knowledge base made of
+// several collections is searched about as fast as its slowest collection.
+// With -retrieval-timeout, the whole search is given a deadline. If any
+// collection can't be searched, the others are canceled, and the error of each
+// failed collection is returned.
+func retrieve(ctx context.Context, db *node.Node, collections []string, queryVector []float32) ([]retrievedDoc, error) {
+	// Every collection shares the same schema, so the same query vector can be
+	// used to search each of them. We then merge the results into a single
+	// ranking.
+	log.Println("Querying DefraDB for similar documents...")
+	ctx, span := tracer.Start(ctx, "rag.retrieve", trace.WithAttributes(
+		attribute.StringSlice("rag.collections", collections),
+	))
+	defer span.End()
+	if *retrievalTimeoutFlag > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *retrievalTimeoutFlag)
+		defer cancel()
+	}
+	limit := maxResults
+	if *recencyWeightFlag > 0 || *mmrLambdaFlag < 1 {
+		limit = maxResults * rerankCandidates
+	}
+
+	// The dimensions are checked before the concurrent searches, which then
+	// don't have to share checkedCollections.
+	for _, collection := range collections {
+		checkCollectionDimension(ctx, db, collection)
+	}
+
+	// Each search writes to its own slot, so no locking is needed, and the
+	// results are put together in the order of the collections rather than
+	// in the order the searches finish. Together with the stable sort of
+	// mergeResults, this keeps the ranking the same from run to run, even
+	// between documents with the same score.
+	results := make([][]retrievedDoc, len(collections))
+	errs := make([]error, len(collections))
+	g, gctx := errgroup.WithContext(ctx)
+	for i, collection := range collections {
+		g.Go(func() error {
+			results[i], errs[i] = searchCollection(gctx, db, collection, documentFields, queryVector, limit)
+			if errs[i] != nil {
+				errs[i] = fmt.Errorf("searching '%s': %w", collection, errs[i])
+			}
+			return errs[i]
+		})
+	}
+	if err := g.Wait(); err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			err = fmt.Errorf("the search took longer than -retrieval-timeout %s", *retrievalTimeoutFlag)
+			endSpan(span, err)
+			return nil, err
+		}
+		err = errors.Join(errs...)
+		endSpan(span, err)
+		return nil, err
+	}
+	var docs []retrievedDoc
+	for _, result := range results {
+		docs = append(docs, result...)
+	}
+
+	if *recencyWeightFlag > 0 {
+		scoreByRecency(docs, *recencyWeightFlag)
+	}
+	span.SetAttributes(attribute.Int("rag.candidates", len(docs)))
+	var merged []retrievedDoc
+	if *mmrLambdaFlag < 1 {
+		merged = selectMMR(mergeResults(docs, len(docs)), maxResults, *mmrLambdaFlag)
+	} else {
+		merged = mergeResults(docs, maxResults)
+	}
+	span.SetAttributes(attribute.Int("rag.results", len(merged)))
+	return merged, nil
+}
+
+// checkCollectionDimension checks the dimension of the embeddings stored in a
+// collection against the query embedding.
+//
+// Comparing vectors of different dimensions would fail, or worse, silently
+// give meaningless similarities. We check each collection once, before
+// searching it for the first time.
+func checkCollectionDimension(ctx context.Context, db *node.Node, collection string) {
+	if checkedCollections[collection] {
+		return
+	}
+	if dim := storedDimension(ctx, db, collection); dim > 0 {
+		checkDimension(fmt.Sprintf("the documents in '%s'", collection), dim)
+	}
+	checkedCollections[collection] = true
+}
+
+// queryCollection returns up to limit documents of a single collection that are
+// most similar to the query vector. fields are the fields to select besides the
+// similarity, which must exist in the collection's schema and be fields of
+// searchHit. `text` is always needed.
+func queryCollection(ctx context.Context, db *node.Node, collection string, fields []string, queryVector []float32, limit int) ([]retrievedDoc, error) {
+	// Now we execute a GraphQL query to find the most relevant documents.
+	// - `_similarity`: This is a special DefraDB operator that calculates the
+	//   cosine similarity between a document's vector field (`text_v`) and a
+	//   provided vector (`$queryVector`).
+	// - `sim: _similarity(...)`: We alias the result of the similarity calculation
+	//   to a field named `sim`.
+	// - `order: {_alias: {sim: DESC}}`: We order the results by the similarity
+	//   score in descending order, so the most relevant documents come first.
+	// - `limit`: We ask for the top most similar documents. Asking each
+	//   collection for as many documents as we want in total guarantees that
+	//   the merged ranking is the same as if all documents were in a single
+	//   collection.
+	// - `filter: {_alias: {sim: {_gt: 0.63}}}`: We filter out results with a
+	//   similarity score below a certain threshold to ensure relevance. This
+	//   threshold may need tuning based on your data and use case.
+	// With MMR, the documents are compared with each other, which requires
+	// their embeddings. Those are large, so they are only fetched then.
+	vectorField := ""
+	if *mmrLambdaFlag < 1 {
+		vectorField = "\n\t\t\t\ttext_v"
+	}
+	request := fmt.Sprintf(`query Search($queryVector: [Float32!]!) {
+			%s(
+				filter: {_alias: {sim: {_gt: 0.63}}},
+				limit: %d,
+				order: {_alias: {sim: DESC}}
+			) {
+				%s
+				sim: _similarity(text_v: {vector: $queryVector})%s
+			}
+		}`, collection, limit, strings.Join(fields, "\n\t\t\t\t"), vectorField)
+	variables := map[string]any{
+		"queryVector": queryVector,
+	}
+	if *printQueryFlag {
+		shared.PrintQuery(request, variables)
+	}
+	queryResult := execRequest(ctx, db, request, client.WithVariables(variables))
+	if len(queryResult.GQL.Errors) > 0 {
+		return nil, errors.Join(queryResult.GQL.Errors...)
+	}
+
+	// Rather than asserting our way through the generic result, we decode it
+	// into typed structs. An unexpected shape then results in an error instead
+	// of a panic.
+	var resultData map[string][]searchHit
+	err := decodeData(queryResult.GQL.Data, &resultData)
+	if err != nil {
+		return nil, fmt.Errorf("unexpected search result from DefraDB: %w", err)
+	}
+
+	hits := resultData[collection]
+	docs := make([]retrievedDoc, 0, len(hits))
+	for _, hit := range hits {
+		// Remember to remove the document prefix we added earlier before
+		// passing the text to the LLM.
+		docs = append(docs, retrievedDoc{
+			Collection: collection,
+			Source:     hit.Source,
+			Category:   hit.Category,
+			Title:      hit.Title,
+			Text:       strings.TrimPrefix(hit.Text, *docPrefixFlag),
+			Similarity: hit.Sim,
+			Date:       hit.Date,
+			Score:      hit.Sim,
+			Vector:     hit.Vector,
+		})
+	}
+	return docs, nil
+}
+
+// searchHit is a document returned by the similarity query of queryCollection.
+type searchHit struct {
+	Text     string `json:"text"`
+	Category string `json:"category"`
+	Title    string `json:"title"`
+	Source   string `json:"source"`
+	// Date is the zero time if the document has no date.
+	Date time.Time `json:"date"`
+	Sim  float64   `json:"sim"`
+	// Vector is only fetched when selecting documents with MMR.
+	Vector []float32 `json:"text_v"`
+}
+
+// decodeData decodes the data of a GraphQL result into v, which should be a
+// pointer to a struct or map matching the shape of the query.
+//
+// The data is made of generic maps and slices. Going through JSON converts it
+// into typed values, and reports an error if it doesn't fit.
+func decodeData(data any, v any) error {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// scoreByRecency sets the score of each document to a mix of its similarity
+// and how recent it is, with weight w given to recency:
+//
+//	score = sim * (1 - w) + recencyNorm * w
+//
+// recencyNorm is the document's date normalized over the candidate set: the
+// oldest dated candidate gets 0 and the newest gets 1, with the others spread
+// linearly in between. If all dated candidates share the same date, they all
+// get 1. Documents without a date get 0, as if they were the oldest.
+func scoreByRecency(docs []retrievedDoc, w float64) {
+	var oldest, newest time.Time
+	for _, doc := range docs {
+		if doc.Date.IsZero() {
+			continue
+		}
+		if oldest.IsZero() || doc.Date.Before(oldest) {
+			oldest = doc.Date
+		}
+		if doc.Date.After(newest) {
+			newest = doc.Date
+		}
+	}
+
+	span := newest.Sub(oldest)
+	for i, doc := range docs {
+		recencyNorm := 0.0
+		switch {
+		case doc.Date.IsZero():
+		case span == 0:
+			recencyNorm = 1
+		default:
+			recencyNorm = float64(doc.Date.Sub(oldest)) / float64(span)
+		}
+		docs[i].Score = doc.Similarity*(1-w) + recencyNorm*w
+	}
+}
+
+// mergeResults orders documents retrieved from several collections by score
+// and keeps the n best ones.
+//
+// The same content can be stored in more than one collection. Only its best
+// scored occurrence is kept, so that it doesn't take up more than one slot in
+// the LLM's context.
+func mergeResults(docs []retrievedDoc, n int) []retrievedDoc {
+	sort.SliceStable(docs, func(i, j int) bool {
+		return docs[i].Score > docs[j].Score
+	})
+
+	seen := map[string]bool{}
+	merged := make([]retrievedDoc, 0, n)
+	for _, doc := range docs {
+		if seen[doc.Text] {
+			continue
+		}
+		seen[doc.Text] = true
+		merged = append(merged, doc)
+		if len(merged) == n {
+			break
+		}
+	}
+	return merged
+}
+
+// selectMMR selects n documents by Maximal Marginal Relevance, from candidates
+// ordered by score.
+//
+// Ranking by similarity alone often retrieves several documents saying the
+// same thing, wasting the LLM's context. MMR picks documents one at a time,
+// each time the one maximizing
+//
+//	lambda * score - (1 - lambda) * max similarity to the documents already picked
+//
+// so that a document similar to one already picked needs a higher score to
+// get in. A lambda of 1 ranks by score alone, and lower values favor
+// diversity.
+func selectMMR(candidates []retrievedDoc, n int, lambda float64) []retrievedDoc {
+	selected := make([]retrievedDoc, 0, n)
+	picked := make([]bool, len(candidates))
+	for len(selected) < n && len(selected) < len(candidates) {
+		best, bestValue := -1, 0.0
+		for i, doc := range candidates {
+			if picked[i] {
+				continue
+			}
+			redundancy := 0.0
+			for j, s := range selected {
+				sim := shared.CosineSimilarity(doc.Vector, s.Vector)
+				if j == 0 || sim > redundancy {
+					redundancy = sim
+				}
+			}
+			value := lambda*doc.Score - (1-lambda)*redundancy
+			if best == -1 || value > bestValue {
+				best, bestValue = i, value
+			}
+		}
+		picked[best] = true
+		selected = append(selected, candidates[best])
+	}
+	return selected
+}
+
+// logDocs logs the retrieved documents and their similarity to the question.
+func logDocs(docs []retrievedDoc) {
+	if len(docs) == 0 {
+		log.Println("No relevant documents found in the knowledge base.")
+		return
+	}
+	log.Println("Found relevant documents:")
+	for i, doc := range docs {
+		collection := colorize(colorCyan, doc.Collection)
+		if doc.Source != "" {
+			collection += ", " + doc.Source
+		}
+		if doc.Category != "" {
+			collection += ", " + doc.Category
+		}
+		if doc.Title != "" {
+			collection += fmt.Sprintf(", %q", doc.Title)
+		}
+		similarity := colorize(colorYellow, fmt.Sprintf("%.4f", doc.Similarity))
+		if *recencyWeightFlag > 0 {
+			score := colorize(colorYellow, fmt.Sprintf("%.4f", doc.Score))
+			log.Printf(" - Document %d (%s, similarity: %s, score: %s): \"%s\"\n", i+1, collection, similarity, score, shared.Truncate(doc.Text, 100))
+			continue
+		}
+		log.Printf(" - Document %d (%s, similarity: %s): \"%s\"\n", i+1, collection, similarity, shared.Truncate(doc.Text, 100))
+	}
+}
+
+// confident reports whether the most similar retrieved document is at least as
+// similar to the question as -min-confidence requires. When it isn't, the
+// knowledge base most likely doesn't hold the answer, and the LLM would only
+// guess, so we don't ask it.
+func confident(docs []retrievedDoc) bool {
+	if *minConfidenceFlag <= -1 {
+		return true
+	}
+	for _, doc := range docs {
+		if doc.Similarity >= *minConfidenceFlag {
+			return true
+		}
+	}
+	log.Printf("No document is similar enough to the question (-min-confidence %v).\n", *minConfidenceFlag)
+	return false
+}
+
+// formatContexts turns retrieved documents into the contexts passed to the
+// LLM. Each context is tagged with the collection it came from, and with the
+// document's category and title when it has them, so that the LLM can tell
+// sources apart and weigh them, e.g.
+//
+//	[Wiki, category: Company, title: "The Monarch Company"] The Monarch Company was...
+func formatContexts(docs []retrievedDoc) []string {
+	contexts := make([]string, 0, len(docs))
+	for _, doc := range docs {
+		label := doc.Collection
+		if doc.Category != "" {
+			label += ", category: " + doc.Category
+		}
+		if doc.Title != "" {
+			label += fmt.Sprintf(", title: %q", doc.Title)
+		}
+		contexts = append(contexts, fmt.Sprintf("[%s] %s", label, doc.Text))
+	}
+	return contexts
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sourcenetwork/defradb/node" // DefraDB node
+)
+
+func TestEmbedQuery(t *testing.T) {
+	e := &fakeEmbedder{vectors: map[string][]float32{
+		*queryPrefixFlag + "Who founded Monarch?": {0.6, 0.8},
+	}}
+	useEmbedder(t, e)
+
+	vector, err := embedQuery(context.Background(), "Who founded Monarch?")
+	if err != nil {
+		t.Fatalf("embedQuery failed: %v", err)
+	}
+	if !slices.Equal(vector, []float32{0.6, 0.8}) {
+		t.Errorf("embedQuery returned %v, want [0.6 0.8]", vector)
+	}
+	// The query must be embedded with the query prefix, to be comparable with
+	// the documents.
+	if !slices.Equal(e.texts, []string{*queryPrefixFlag + "Who founded Monarch?"}) {
+		t.Errorf("embedded %q, want the question with the query prefix", e.texts)
+	}
+}
+
+func TestEmbedQueryUnavailable(t *testing.T) {
+	useEmbedder(t, &fakeEmbedder{err: errors.New("connection refused")})
+
+	_, err := embedQuery(context.Background(), "Who founded Monarch?")
+	if !errors.Is(err, errEmbeddingUnavailable) {
+		t.Errorf("embedQuery returned %v, want an error wrapping errEmbeddingUnavailable", err)
+	}
+}
+
+func TestDecodeData(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    any
+		wantErr bool
+	}{
+		{
+			name: "well-formed",
+			data: map[string]any{"Wiki": []map[string]any{{"text": "Monarch was founded in 1998.", "sim": 0.9}}},
+		},
+		{name: "no data", data: nil},
+		{name: "collection is not a list", data: map[string]any{"Wiki": "oops"}, wantErr: true},
+		{name: "field has the wrong type", data: map[string]any{"Wiki": []map[string]any{{"text": 42}}}, wantErr: true},
+		{name: "data is not an object", data: []any{"Wiki"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var resultData map[string][]searchHit
+			err := decodeData(tt.data, &resultData)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("decodeData returned %v, want an error: %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestScoreByRecency(t *testing.T) {
+	day := func(n int) time.Time {
+		return time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC).AddDate(0, 0, n)
+	}
+	tests := []struct {
+		name  string
+		dates []time.Time
+		sims  []float64
+		w     float64
+		want  []float64
+	}{
+		{
+			name:  "pure similarity",
+			dates: []time.Time{day(0), day(20)},
+			sims:  []float64{0.9, 0.5},
+			w:     0,
+			want:  []float64{0.9, 0.5},
+		},
+		{
+			name:  "pure recency",
+			dates: []time.Time{day(0), day(20)},
+			sims:  []float64{0.9, 0.5},
+			w:     1,
+			want:  []float64{0, 1},
+		},
+		{
+			// The oldest document gets a recency of 0, the newest 1, and the
+			// one halfway between them 0.5.
+			name:  "oldest, midpoint and newest",
+			dates: []time.Time{day(0), day(10), day(20)},
+			sims:  []float64{0.8, 0.8, 0.8},
+			w:     0.5,
+			want:  []float64{0.4, 0.65, 0.9},
+		},
+		{
+			name:  "around the midpoint",
+			dates: []time.Time{day(0), day(9), day(11), day(20)},
+			sims:  []float64{0.8, 0.8, 0.8, 0.8},
+			w:     0.5,
+			want:  []float64{0.4, 0.625, 0.675, 0.9},
+		},
+		{
+			name:  "single date",
+			dates: []time.Time{day(5), day(5)},
+			sims:  []float64{0.8, 0.6},
+			w:     0.5,
+			want:  []float64{0.9, 0.8},
+		},
+		{
+			// A document without a date counts as the oldest.
+			name:  "undated",
+			dates: []time.Time{{}, day(0), day(20)},
+			sims:  []float64{0.8, 0.8, 0.8},
+			w:     0.5,
+			want:  []float64{0.4, 0.4, 0.9},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			docs := make([]retrievedDoc, len(tt.dates))
+			for i := range docs {
+				docs[i] = retrievedDoc{Similarity: tt.sims[i], Date: tt.dates[i]}
+			}
+			scoreByRecency(docs, tt.w)
+			for i, doc := range docs {
+				if math.Abs(doc.Score-tt.want[i]) > 1e-9 {
+					t.Errorf("document %d got a score of %g, want %g", i, doc.Score, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestSelectMMR(t *testing.T) {
+	// "a copy" says the same thing as "a", and "b" something else.
+	candidates := []retrievedDoc{
+		{Text: "a", Score: 0.9, Vector: []float32{1, 0}},
+		{Text: "a copy", Score: 0.85, Vector: []float32{1, 0}},
+		{Text: "b", Score: 0.6, Vector: []float32{0, 1}},
+	}
+	tests := []struct {
+		name   string
+		n      int
+		lambda float64
+		want   []string
+	}{
+		{name: "relevance only", n: 2, lambda: 1, want: []string{"a", "a copy"}},
+		{name: "balanced", n: 2, lambda: 0.5, want: []string{"a", "b"}},
+		{name: "diversity only", n: 2, lambda: 0, want: []string{"a", "b"}},
+		{name: "all candidates", n: 3, lambda: 0.5, want: []string{"a", "b", "a copy"}},
+		{name: "more than the candidates", n: 5, lambda: 1, want: []string{"a", "a copy", "b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var texts []string
+			for _, doc := range selectMMR(candidates, tt.n, tt.lambda) {
+				texts = append(texts, doc.Text)
+			}
+			if !slices.Equal(texts, tt.want) {
+				t.Errorf("selectMMR selected %q, want %q", texts, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetrieveTimeout(t *testing.T) {
+	// The collections are marked as checked, so that retrieve doesn't look up
+	// their embedding dimension in DefraDB.
+	previousTimeout, previousSearch := *retrievalTimeoutFlag, searchCollection
+	*retrievalTimeoutFlag = 50 * time.Millisecond
+	checkedCollections["Fast"], checkedCollections["Slow"] = true, true
+	t.Cleanup(func() {
+		*retrievalTimeoutFlag, searchCollection = previousTimeout, previousSearch
+		delete(checkedCollections, "Fast")
+		delete(checkedCollections, "Slow")
+	})
+
+	// "Slow" answers only when its search is cancelled.
+	cancelled := make(chan struct{})
+	searchCollection = func(ctx context.Context, db *node.Node, collection string, fields []string, queryVector []float32, limit int) ([]retrievedDoc, error) {
+		if collection == "Fast" {
+			return []retrievedDoc{{Collection: collection, Text: "a", Score: 0.9}}, nil
+		}
+		<-ctx.Done()
+		close(cancelled)
+		return nil, ctx.Err()
+	}
+
+	start := time.Now()
+	docs, err := retrieve(context.Background(), nil, []string{"Fast", "Slow"}, []float32{1, 0})
+	if err == nil || !strings.Contains(err.Error(), "-retrieval-timeout") {
+		t.Errorf("retrieve returned %v, %v, want a timeout error", docs, err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("retrieve returned after %s, want shortly after the 50ms timeout", elapsed)
+	}
+	select {
+	case <-cancelled:
+	default:
+		t.Error("the slow search wasn't cancelled")
+	}
+}
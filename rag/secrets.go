@@ -0,0 +1,65 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+)
+
+// secretFlags are the flags holding secrets, which are never printed.
+var secretFlags = map[string]bool{"api-key": true}
+
+// redacted replaces a secret wherever it would be printed.
+const redacted = "[REDACTED]"
+
+// redactingWriter is an io.Writer masking known secret values in everything
+// written to it.
+type redactingWriter struct {
+	w       io.Writer
+	secrets []string
+}
+
+// Write implements io.Writer. It reports the length of p, so that callers
+// don't take the masking for a short write.
+func (r *redactingWriter) Write(p []byte) (int, error) {
+	s := string(p)
+	for _, secret := range r.secrets {
+		s = strings.ReplaceAll(s, secret, redacted)
+	}
+	_, err := io.WriteString(r.w, s)
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// redactLogs masks the values of the secret flags in the log, e.g. in an error
+// message echoing a request. It only covers the example's own log: DefraDB
+// logs with its own logger, but is never given these secrets.
+func redactLogs() {
+	var secrets []string
+	for name := range secretFlags {
+		if value := flag.Lookup(name).Value.String(); value != "" {
+			secrets = append(secrets, value)
+		}
+	}
+	if len(secrets) > 0 {
+		log.SetOutput(&redactingWriter{w: os.Stderr, secrets: secrets})
+	}
+}
+
+// printConfig prints the value of every flag to w, one `-name=value` per line,
+// with the secrets masked. Secrets read from the environment are included,
+// since they are assigned to their flags.
+func printConfig(w io.Writer) {
+	flag.VisitAll(func(f *flag.Flag) {
+		value := f.Value.String()
+		if secretFlags[f.Name] && value != "" {
+			value = redacted
+		}
+		fmt.Fprintf(w, "-%s=%s\n", f.Name, value)
+	})
+}
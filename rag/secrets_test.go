@@ -0,0 +1,38 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRedactingWriter(t *testing.T) {
+	var out strings.Builder
+	w := &redactingWriter{w: &out, secrets: []string{"sk-secret"}}
+
+	line := "request failed: Authorization: Bearer sk-secret\n"
+	n, err := w.Write([]byte(line))
+	if err != nil || n != len(line) {
+		t.Fatalf("Write returned %d, %v, want %d, nil", n, err, len(line))
+	}
+	if want := "request failed: Authorization: Bearer [REDACTED]\n"; out.String() != want {
+		t.Errorf("wrote %q, want %q", out.String(), want)
+	}
+}
+
+func TestPrintConfig(t *testing.T) {
+	previous := *apiKeyFlag
+	*apiKeyFlag = "sk-secret"
+	t.Cleanup(func() { *apiKeyFlag = previous })
+
+	var out strings.Builder
+	printConfig(&out)
+	if strings.Contains(out.String(), "sk-secret") {
+		t.Error("the API key is printed")
+	}
+	if !strings.Contains(out.String(), "-api-key=[REDACTED]\n") {
+		t.Errorf("the API key isn't shown as masked:\n%s", out.String())
+	}
+	if !strings.Contains(out.String(), "-collections=Wiki\n") {
+		t.Errorf("the other flags aren't printed:\n%s", out.String())
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"     // OpenAI client, compatible with Ollama's API
+	"github.com/sourcenetwork/defradb/node" // DefraDB node
+)
+
+const (
+	// searchToolName is the name of the tool the LLM calls to search the
+	// knowledge base.
+	searchToolName = "search_knowledge_base"
+
+	// maxToolRounds is the number of times the LLM may call tools before it
+	// must answer. It stops a model that keeps searching from looping forever.
+	maxToolRounds = 3
+
+	// toolsSystemPrompt replaces the system prompt of askLLM when the LLM
+	// retrieves the contexts itself.
+	toolsSystemPrompt = "You are a helpful assistant. Use the " + searchToolName + " tool to find facts " +
+		"about the question before answering it. Answer the question in a very concise manner, using " +
+		"only the facts found. If they don't contain the answer, say that you don't know."
+)
+
+// searchTool describes the knowledge base search to the LLM. The parameters
+// are a JSON Schema of the arguments the LLM passes when calling it.
+var searchTool = openai.Tool{
+	Type: openai.ToolTypeFunction,
+	Function: &openai.FunctionDefinition{
+		Name:        searchToolName,
+		Description: "Search the knowledge base for documents relevant to a query.",
+		Parameters: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"query": map[string]any{
+					"type":        "string",
+					"description": "What to search for, e.g. the question or a part of it.",
+				},
+			},
+			"required": []string{"query"},
+		},
+	},
+}
+
+// askWithTools answers a question by letting the LLM search the knowledge base
+// itself, with function calling, instead of adding the retrieved documents to
+// the prompt up front like askLLM.
+//
+// The LLM is given the search_knowledge_base tool. When it calls it, we run the
+// same similarity search as the classic pipeline with the query it chose, and
+// send the documents back as the tool's result. The LLM can then search again,
+// or answer.
+//
+// Not all models support function calling. If Ollama rejects the tool, ok is
+// false, and the caller falls back to classic RAG.
+func askWithTools(ctx context.Context, db *node.Node, collections []string, question string) (answer string, ok bool) {
+	messages := []openai.ChatCompletionMessage{
+		{
+			Role:    openai.ChatMessageRoleUser,
+			Content: "Question: " + question,
+		},
+	}
+	for round := 0; ; round++ {
+		// After the last round, the tool is no longer offered, so the LLM has
+		// to answer with what it found.
+		var tools []openai.Tool
+		if round < maxToolRounds {
+			tools = []openai.Tool{searchTool}
+		}
+		chatCtx, span := tracer.Start(ctx, "ollama.chat")
+		msg, err := textGenerator.GenerateWithTools(chatCtx, toolsSystemPrompt, messages, tools)
+		endSpan(span, err)
+		if err != nil && strings.Contains(err.Error(), "does not support tools") {
+			log.Printf("The model %q doesn't support function calling.\n", *llmModelFlag)
+			return "", false
+		}
+		if err != nil {
+			log.Fatalf("Ollama chat completion failed: %v", err)
+		}
+
+		if len(msg.ToolCalls) == 0 {
+			return strings.TrimSpace(msg.Content), true
+		}
+
+		// The assistant message with the tool calls must be part of the
+		// conversation, followed by one result for each call.
+		messages = append(messages, msg)
+		for _, call := range msg.ToolCalls {
+			messages = append(messages, openai.ChatCompletionMessage{
+				Role:       openai.ChatMessageRoleTool,
+				Content:    runToolCall(ctx, db, collections, call),
+				ToolCallID: call.ID,
+			})
+		}
+	}
+}
+
+// runToolCall runs a tool call of the LLM and returns its result.
+//
+// Errors are returned to the LLM as the result rather than ending the program:
+// a small model may well call a tool that doesn't exist, or with malformed
+// arguments, and can recover when told so.
+func runToolCall(ctx context.Context, db *node.Node, collections []string, call openai.ToolCall) string {
+	if call.Function.Name != searchToolName {
+		log.Printf("The LLM called the unknown tool %q.\n", call.Function.Name)
+		return "Error: unknown tool " + call.Function.Name
+	}
+	var args struct {
+		Query string `json:"query"`
+	}
+	err := json.Unmarshal([]byte(call.Function.Arguments), &args)
+	if err != nil || strings.TrimSpace(args.Query) == "" {
+		log.Printf("The LLM called %s with invalid arguments: %s\n", searchToolName, call.Function.Arguments)
+		return `Error: the arguments must be a JSON object with a non-empty "query" string.`
+	}
+
+	log.Printf("The LLM searches the knowledge base for %q.\n", args.Query)
+	queryVector, err := embedQuery(ctx, args.Query)
+	if err != nil {
+		log.Printf("Failed to create query embedding: %v\n", err)
+		return "Error: the knowledge base can't be searched right now (" + errEmbeddingUnavailable.Error() + ")."
+	}
+	docs, err := retrieve(ctx, db, collections, queryVector)
+	if err != nil {
+		log.Printf("Failed to search the knowledge base: %v\n", err)
+		return "Error: the knowledge base search failed."
+	}
+	logDocs(docs)
+	if len(docs) == 0 {
+		return "No documents found."
+	}
+	return strings.Join(formatContexts(docs), "\n")
+}
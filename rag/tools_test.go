@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/sashabaranov/go-openai" // OpenAI client, compatible with Ollama's API
+)
+
+func TestAskWithToolsUnknownTool(t *testing.T) {
+	g := &fakeGenerator{replies: []openai.ChatCompletionMessage{
+		{
+			Role: openai.ChatMessageRoleAssistant,
+			ToolCalls: []openai.ToolCall{{
+				ID:       "call-1",
+				Type:     openai.ToolTypeFunction,
+				Function: openai.FunctionCall{Name: "lookup", Arguments: "{}"},
+			}},
+		},
+		{Role: openai.ChatMessageRoleAssistant, Content: "I don't know."},
+	}}
+	useGenerator(t, g)
+
+	// A call to a tool that doesn't exist doesn't search the knowledge base,
+	// so no DefraDB node is needed.
+	answer, ok := askWithTools(context.Background(), nil, nil, "When was Monarch founded?")
+	if !ok || answer != "I don't know." {
+		t.Errorf("askWithTools returned %q, %v, want the final answer", answer, ok)
+	}
+	if g.systems[0] != toolsSystemPrompt {
+		t.Errorf("askWithTools used the system prompt %q", g.systems[0])
+	}
+	if len(g.tools[0]) != 1 || g.tools[0][0].Function.Name != searchToolName {
+		t.Errorf("askWithTools offered %+v, want the search tool", g.tools[0])
+	}
+	// The second request continues the conversation with the result of the
+	// tool call, which tells the LLM the tool doesn't exist.
+	last := g.requests[1][len(g.requests[1])-1]
+	if last.Role != openai.ChatMessageRoleTool || last.ToolCallID != "call-1" || !strings.Contains(last.Content, "unknown tool") {
+		t.Errorf("the tool result is %+v", last)
+	}
+}
+
+func TestAskWithToolsUnsupported(t *testing.T) {
+	useGenerator(t, &fakeGenerator{err: errors.New(`registry.ollama.ai/library/llama3.2 does not support tools`)})
+
+	_, ok := askWithTools(context.Background(), nil, nil, "When was Monarch founded?")
+	if ok {
+		t.Error("askWithTools succeeded with a model without tool support")
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strings"
+
+	"github.com/sourcenetwork/defradb/client"                         // DefraDB client
+	"github.com/sourcenetwork/defradb/node"                           // DefraDB node
+	"go.opentelemetry.io/otel"                                        // OpenTelemetry API
+	"go.opentelemetry.io/otel/attribute"                              // Span attributes
+	"go.opentelemetry.io/otel/codes"                                  // Span status codes
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp" // OTLP exporter over HTTP
+	"go.opentelemetry.io/otel/sdk/resource"                           // Service description
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"                     // OpenTelemetry tracing SDK
+	"go.opentelemetry.io/otel/trace"                                  // Tracing API
+)
+
+// tracer creates the spans of the example. Until setupTracing installs an
+// exporter, it is a no-op, so tracing costs nothing when it is disabled.
+var tracer = otel.Tracer("github.com/sourcenetwork/examples/rag")
+
+// setupTracing exports the spans of the example to the OTLP/HTTP endpoint at
+// endpointURL, e.g. `http://localhost:4318` for a local Jaeger or OpenTelemetry
+// Collector. The returned function flushes the remaining spans and must be
+// called before exiting.
+func setupTracing(ctx context.Context, endpointURL string) func(context.Context) {
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(endpointURL))
+	if err != nil {
+		log.Fatalf("Failed to create trace exporter: %v", err)
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", "rag"))),
+	)
+	otel.SetTracerProvider(provider)
+	log.Printf("Exporting traces to %s\n", endpointURL)
+
+	return func(ctx context.Context) {
+		err := provider.Shutdown(ctx)
+		if err != nil {
+			log.Printf("Failed to flush traces: %v\n", err)
+		}
+	}
+}
+
+// execRequest runs a GraphQL request against DefraDB in a span, recording the
+// operation, the number of documents returned and any errors.
+func execRequest(ctx context.Context, db *node.Node, request string, opts ...client.RequestOption) *client.RequestResult {
+	opType, opName := describeRequest(request)
+	ctx, span := tracer.Start(ctx, "defradb.ExecRequest", trace.WithAttributes(
+		attribute.String("graphql.operation.type", opType),
+		attribute.String("graphql.operation.name", opName),
+	))
+	defer span.End()
+
+	result := db.DB.ExecRequest(ctx, request, opts...)
+
+	// Every top-level field of a query or mutation returns a list of documents.
+	docs := 0
+	if data, ok := result.GQL.Data.(map[string]any); ok {
+		for _, v := range data {
+			if list, ok := v.([]map[string]any); ok {
+				docs += len(list)
+			}
+		}
+	}
+	span.SetAttributes(attribute.Int("defradb.documents", docs))
+	if len(result.GQL.Errors) > 0 {
+		for _, err := range result.GQL.Errors {
+			span.RecordError(err)
+		}
+		span.SetStatus(codes.Error, result.GQL.Errors[0].Error())
+	}
+	return result
+}
+
+// describeRequest returns the operation type (e.g. `query` or `mutation`) and
+// the operation name, if any, of a GraphQL request.
+func describeRequest(request string) (opType string, opName string) {
+	request = strings.TrimSpace(request)
+	fields := strings.FieldsFunc(request, func(r rune) bool {
+		return r == ' ' || r == '\t' || r == '\n' || r == '(' || r == '{'
+	})
+	if len(fields) > 0 {
+		opType = fields[0]
+	}
+	if len(fields) > 1 && !strings.HasPrefix(strings.TrimSpace(request[len(opType):]), "{") {
+		opName = fields[1]
+	}
+	return opType, opName
+}
+
+// endSpan ends a span, marking it as failed if err is not nil.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"math/rand/v2"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"                 // libp2p peer addresses
+	"github.com/sourcenetwork/defradb/client"               // DefraDB client
+	netConfig "github.com/sourcenetwork/defradb/net/config" // DefraDB P2P networking options
+	"github.com/sourcenetwork/defradb/node"                 // DefraDB node
+)
+
+// This example demonstrates how an embedded DefraDB node at the edge pushes a
+// collection to a central DefraDB server, using a one-way replicator.
+//
+// The process is as follows:
+// 1. Start an embedded node with P2P enabled.
+// 2. Add the collection schema. The remote server must have the same schema.
+// 3. Configure the remote server as a replicator of the embedded node for
+//    that collection. The embedded node pushes every change in the collection
+//    to the server, and only in that direction.
+// 4. Write a new sensor reading on a schedule, until interrupted with Ctrl-C.
+// 5. Keep an eye on the replicator status. When the server goes down, the
+//    replicator becomes inactive and the readings pile up locally. DefraDB
+//    keeps retrying in the background, and once the server is back, the
+//    replicator becomes active again and the pending readings are pushed.
+//
+// Usage:
+//
+//	go run . -remote /ip4/<host>/tcp/9171/p2p/<peer ID> [-interval 5s]
+//
+// Prerequisites:
+// - A DefraDB server reachable from this machine, with P2P enabled and the
+//   `Reading` collection schema added (see README.md).
+
+const (
+	// collectionName is the collection pushed to the remote server.
+	collectionName = "Reading"
+
+	// sensorName identifies the readings written by this node.
+	sensorName = "edge-sensor-1"
+)
+
+var (
+	// remoteFlag is the full P2P address of the remote server, including its
+	// peer ID.
+	remoteFlag = flag.String("remote", "", "P2P address of the remote DefraDB server, e.g. /ip4/10.0.0.1/tcp/9171/p2p/12D3KooW...")
+
+	// intervalFlag is how often a new reading is written.
+	intervalFlag = flag.Duration("interval", 5*time.Second, "how often to write a new reading")
+)
+
+func main() {
+	flag.Parse()
+	if *remoteFlag == "" {
+		log.Fatalf("-remote is required.")
+	}
+	if *intervalFlag <= 0 {
+		log.Fatalf("-interval must be positive, got %s", *intervalFlag)
+	}
+
+	// A replicator needs both the address and the peer ID of the remote node,
+	// which is exactly what a multiaddr ending in `/p2p/<peer ID>` provides.
+	remote, err := peer.AddrInfoFromString(*remoteFlag)
+	if err != nil {
+		log.Fatalf("Invalid -remote address %q: %v", *remoteFlag, err)
+	}
+
+	// We run until interrupted with Ctrl-C.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	// --- Step 1: Start the embedded node ---
+	log.Println("================================================================================")
+	log.Println("Start the embedded DefraDB node")
+	log.Println("================================================================================")
+	db := startNode(ctx)
+	defer db.Close(context.Background())
+
+	// --- Step 2: Add the schema and set up replication ---
+	log.Println("================================================================================")
+	log.Println("Add the schema and replicate it to the remote server")
+	log.Println("================================================================================")
+
+	// The remote server must know the collection too, with the exact same
+	// schema, or it will reject the pushed documents.
+	log.Printf("Adding '%s' collection schema...\n", collectionName)
+	_, err = db.DB.AddSchema(ctx, `type Reading {
+		sensor: String
+		value: Float
+		takenAt: DateTime
+	}`)
+	if err != nil {
+		log.Fatalf("Failed to add schema: %v", err)
+	}
+
+	// A replicator is a one-way relationship: this node pushes every change to
+	// the given collections to the remote server, but never receives changes
+	// from it. This is a good fit for edge nodes feeding a central server.
+	//
+	// Setting the replicator connects to the remote server, so it must be
+	// reachable at this point. Later outages are handled by DefraDB.
+	log.Printf("Setting %s as a replicator...\n", remote.ID)
+	err = db.Peer.SetReplicator(ctx, *remote, collectionName)
+	if err != nil {
+		log.Fatalf("Failed to set replicator: %v", err)
+	}
+
+	// --- Step 3: Write readings on a schedule ---
+	log.Println("================================================================================")
+	log.Printf("Write a reading every %s (press Ctrl-C to stop)\n", *intervalFlag)
+	log.Println("================================================================================")
+
+	ticker := time.NewTicker(*intervalFlag)
+	defer ticker.Stop()
+	active := true
+	for {
+		writeReading(ctx, db)
+
+		// Writes never fail because the remote server is down: the document
+		// is stored locally and pushed later. The replicator status is how we
+		// find out whether the pushes are currently getting through.
+		if status, since, ok := replicatorStatus(ctx, db, remote.ID); ok {
+			switch {
+			case active && status == client.ReplicatorStatusInactive:
+				log.Printf("Remote server unreachable since %s, readings are kept locally and retried.\n", since.Format(time.TimeOnly))
+				active = false
+			case !active && status == client.ReplicatorStatusActive:
+				log.Printf("Remote server reachable again since %s, pending readings are being pushed.\n", since.Format(time.TimeOnly))
+				active = true
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Println("Stopping.")
+			return
+		case <-ticker.C:
+		}
+	}
+
+	/* Output (peer IDs and document IDs differ on each run):
+	2024/08/02 14:30:12 Edge node listening as 12D3KooW... on [/ip4/0.0.0.0/tcp/40123]
+	...
+	2024/08/02 14:30:12 Wrote reading 21.37 (bae-2c1f...)
+	2024/08/02 14:30:17 Wrote reading 21.52 (bae-8a0d...)
+	2024/08/02 14:30:22 Wrote reading 21.49 (bae-5e7b...)
+	2024/08/02 14:30:22 Remote server unreachable since 14:30:20, readings are kept locally and retried.
+	...
+	2024/08/02 14:31:02 Wrote reading 21.61 (bae-91c4...)
+	2024/08/02 14:31:02 Remote server reachable again since 14:31:00, pending readings are being pushed.
+	*/
+}
+
+// startNode creates and starts an in-memory DefraDB node with P2P enabled,
+// listening on a random port on all interfaces.
+func startNode(ctx context.Context) *node.Node {
+	log.Println("Starting edge node...")
+	n, err := node.New(
+		ctx,
+		node.WithBadgerInMemory(true),
+		node.WithDisableAPI(true),
+		netConfig.WithListenAddresses("/ip4/0.0.0.0/tcp/0"),
+	)
+	if err != nil {
+		log.Fatalf("Failed to create DefraDB node: %v", err)
+	}
+	err = n.Start(ctx)
+	if err != nil {
+		log.Fatalf("Failed to start DefraDB node: %v", err)
+	}
+	info := n.Peer.PeerInfo()
+	log.Printf("Edge node listening as %s on %v\n", info.ID, info.Addrs)
+	return n
+}
+
+// writeReading creates a new reading with a simulated temperature value.
+func writeReading(ctx context.Context, db *node.Node) {
+	value := 20 + rand.Float64()*3
+	result := db.DB.ExecRequest(
+		ctx,
+		`mutation CreateReading($input: [ReadingMutationInputArg!]!) {
+			create_Reading(input: $input) {
+				_docID
+			}
+		}`,
+		client.WithVariables(map[string]any{
+			"input": map[string]any{
+				"sensor":  sensorName,
+				"value":   value,
+				"takenAt": time.Now().UTC().Format(time.RFC3339),
+			},
+		}),
+	)
+	if len(result.GQL.Errors) > 0 {
+		if ctx.Err() != nil {
+			// Ctrl-C was pressed during the write, which is cancelled with
+			// it. The loop stops cleanly after this.
+			log.Println("Reading not written: interrupted.")
+			return
+		}
+		for _, gqlErr := range result.GQL.Errors {
+			log.Printf("GraphQL error on create: %v\n", gqlErr)
+		}
+		log.Fatalf("Failed to create reading.")
+	}
+	data, _ := result.GQL.Data.(map[string]any)
+	readings, _ := data["create_Reading"].([]map[string]any)
+	if len(readings) == 0 {
+		log.Fatalf("Unexpected create result from DefraDB: %v", result.GQL.Data)
+	}
+	docID, ok := readings[0]["_docID"].(string)
+	if !ok {
+		log.Fatalf("Unexpected create result from DefraDB: %v", result.GQL.Data)
+	}
+	log.Printf("Wrote reading %.2f (%s)\n", value, docID)
+}
+
+// replicatorStatus returns the status of the replicator for the given peer,
+// and when it last changed. ok is false if there is no such replicator.
+func replicatorStatus(ctx context.Context, db *node.Node, id peer.ID) (status client.ReplicatorStatus, since time.Time, ok bool) {
+	reps, err := db.Peer.GetAllReplicators(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("Failed to get replicators: %v\n", err)
+		}
+		return 0, time.Time{}, false
+	}
+	for _, rep := range reps {
+		if rep.Info.ID == id {
+			return rep.Status, rep.LastStatusChange, true
+		}
+	}
+	return 0, time.Time{}, false
+}
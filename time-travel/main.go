@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sourcenetwork/defradb/client" // DefraDB client
+	"github.com/sourcenetwork/defradb/node"   // DefraDB node
+)
+
+// This example demonstrates time-travel queries: reading a document as it was
+// at a past commit.
+//
+// The process is as follows:
+// 1. Start an in-memory DefraDB node and add a `Product` collection.
+// 2. Create a product and change its price a few times. Every change produces
+//    a new commit, identified by a content identifier (CID).
+// 3. List the document's commits, and fetch the document as it was at each of
+//    them with a time-travel query (`cid` argument).
+// 4. Resolve the -at flag to a commit and print the historical value. -at is
+//    either a CID, or a point in time. DefraDB commits don't carry a
+//    timestamp, so a time is resolved using the document's own `updatedAt`
+//    field: the last commit whose `updatedAt` is not after it is used.
+//
+// Usage:
+//
+//	go run . [-at <cid-or-RFC3339-time>]
+//
+// Prerequisites:
+// - None. The node runs entirely in memory.
+
+// priceChanges are the prices written to the product, in order, with the time
+// of each change.
+var priceChanges = []struct {
+	Price     float64
+	UpdatedAt string
+}{
+	{9.99, "2024-01-01T00:00:00Z"},
+	{12.49, "2024-03-01T00:00:00Z"},
+	{11.99, "2024-06-01T00:00:00Z"},
+	{14.99, "2024-09-01T00:00:00Z"},
+}
+
+// atFlag is the commit CID or time to read the product at.
+var atFlag = flag.String("at", "2024-04-15T00:00:00Z", "commit CID or RFC 3339 time to read the product at")
+
+// version is the product as it was at a commit.
+type version struct {
+	CID       string
+	Height    int64
+	Price     any
+	UpdatedAt time.Time
+}
+
+func main() {
+	flag.Parse()
+	ctx := context.Background()
+
+	// --- Step 1: Set up DefraDB ---
+	log.Println("================================================================================")
+	log.Println("Set up DefraDB")
+	log.Println("================================================================================")
+	log.Println("Setting up DefraDB...")
+	db, err := node.New(ctx, node.WithBadgerInMemory(true), node.WithDisableAPI(true), node.WithDisableP2P(true))
+	if err != nil {
+		log.Fatalf("Failed to create DefraDB node: %v", err)
+	}
+	defer db.Close(ctx)
+	err = db.Start(ctx)
+	if err != nil {
+		log.Fatalf("Failed to start DefraDB node: %v", err)
+	}
+
+	log.Println("Adding 'Product' collection schema to DefraDB...")
+	_, err = db.DB.AddSchema(ctx, `type Product {
+		name: String
+		price: Float
+		updatedAt: DateTime
+	}`)
+	if err != nil {
+		log.Fatalf("Failed to add schema: %v", err)
+	}
+
+	// --- Step 2: Write the price history ---
+	log.Println("================================================================================")
+	log.Println("Create a product and change its price")
+	log.Println("================================================================================")
+	createResult := db.DB.ExecRequest(
+		ctx,
+		`mutation CreateProduct($input: [ProductMutationInputArg!]!) {
+			create_Product(input: $input) {
+				_docID
+			}
+		}`,
+		client.WithVariables(map[string]any{
+			"input": map[string]any{
+				"name":      "Coffee beans",
+				"price":     priceChanges[0].Price,
+				"updatedAt": priceChanges[0].UpdatedAt,
+			},
+		}),
+	)
+	checkErrors("create", createResult)
+	createData, _ := createResult.GQL.Data.(map[string]any)
+	created, _ := createData["create_Product"].([]map[string]any)
+	if len(created) == 0 {
+		log.Fatalf("Unexpected create result from DefraDB: %v", createResult.GQL.Data)
+	}
+	docID, ok := created[0]["_docID"].(string)
+	if !ok {
+		log.Fatalf("Unexpected create result from DefraDB: %v", createResult.GQL.Data)
+	}
+	log.Printf("Created product %s at %.2f on %s\n", docID, priceChanges[0].Price, priceChanges[0].UpdatedAt)
+
+	// Each update adds a commit on top of the previous one. The old values are
+	// not overwritten; they remain reachable through the older commits.
+	for _, change := range priceChanges[1:] {
+		updateResult := db.DB.ExecRequest(
+			ctx,
+			// The docID argument of updates is a list of IDs.
+			`mutation UpdateProduct($docID: [ID!], $input: ProductMutationInputArg!) {
+				update_Product(docID: $docID, input: $input) {
+					_docID
+				}
+			}`,
+			client.WithVariables(map[string]any{
+				"docID": []string{docID},
+				"input": map[string]any{
+					"price":     change.Price,
+					"updatedAt": change.UpdatedAt,
+				},
+			}),
+		)
+		checkErrors("update", updateResult)
+		log.Printf("Changed the price to %.2f on %s\n", change.Price, change.UpdatedAt)
+	}
+
+	// --- Step 3: Read every version ---
+	log.Println("================================================================================")
+	log.Println("Read the product at each of its commits")
+	log.Println("================================================================================")
+	versions := history(ctx, db, docID)
+	for _, v := range versions {
+		log.Printf(" - height %d: %s price=%v updatedAt=%s\n", v.Height, v.CID, v.Price, v.UpdatedAt.Format(time.DateOnly))
+	}
+
+	// --- Step 4: Time travel ---
+	log.Println("================================================================================")
+	log.Printf("Read the product at %s\n", *atFlag)
+	log.Println("================================================================================")
+	cid := resolveAt(versions, *atFlag)
+	v := readAt(ctx, db, docID, cid)
+	log.Printf("At commit %s, the price was %v (last updated %s)\n", cid, v.Price, v.UpdatedAt.Format(time.DateOnly))
+
+	/* Output (document IDs and CIDs differ on each run):
+	...
+	2024/08/02 14:30:12 ================================================================================
+	2024/08/02 14:30:12 Read the product at each of its commits
+	2024/08/02 14:30:12 ================================================================================
+	2024/08/02 14:30:12  - height 1: bafyreia... price=9.99 updatedAt=2024-01-01
+	2024/08/02 14:30:12  - height 2: bafyreib... price=12.49 updatedAt=2024-03-01
+	2024/08/02 14:30:12  - height 3: bafyreic... price=11.99 updatedAt=2024-06-01
+	2024/08/02 14:30:12  - height 4: bafyreid... price=14.99 updatedAt=2024-09-01
+	2024/08/02 14:30:12 ================================================================================
+	2024/08/02 14:30:12 Read the product at 2024-04-15T00:00:00Z
+	2024/08/02 14:30:12 ================================================================================
+	2024/08/02 14:30:12 At commit bafyreib..., the price was 12.49 (last updated 2024-03-01)
+	*/
+}
+
+// history returns the product as it was at each of its composite commits,
+// oldest first.
+//
+// Each document change produces one composite commit (field name `_C`), which
+// covers all the fields changed together. Those are the commits a document can
+// be read at.
+func history(ctx context.Context, db *node.Node, docID string) []version {
+	result := db.DB.ExecRequest(
+		ctx,
+		`query Commits($docID: ID!) {
+			commits(docID: $docID, fieldName: "_C") {
+				cid
+				height
+			}
+		}`,
+		client.WithVariables(map[string]any{"docID": docID}),
+	)
+	checkErrors("commits query", result)
+
+	data, _ := result.GQL.Data.(map[string]any)
+	commits, ok := data["commits"].([]map[string]any)
+	if !ok {
+		log.Fatalf("Unexpected commits query result from DefraDB: %v", result.GQL.Data)
+	}
+	versions := make([]version, 0, len(commits))
+	for _, commit := range commits {
+		cid, cidOK := commit["cid"].(string)
+		height, heightOK := commit["height"].(int64)
+		if !cidOK || !heightOK {
+			log.Fatalf("Unexpected commit in DefraDB's result: %v", commit)
+		}
+		v := readAt(ctx, db, docID, cid)
+		v.Height = height
+		versions = append(versions, v)
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].Height < versions[j].Height
+	})
+	return versions
+}
+
+// readAt runs a time-travel query, returning the product as it was at the
+// given commit.
+//
+// A time-travel query is a regular query with a `cid` argument, which must be
+// combined with the document's `docID`. DefraDB rebuilds the document from the
+// commits up to and including the given one.
+func readAt(ctx context.Context, db *node.Node, docID string, cid string) version {
+	result := db.DB.ExecRequest(
+		ctx,
+		// Unlike in mutations, the docID argument of queries is a list of
+		// strings.
+		`query ProductAt($cid: String!, $docID: [String!]) {
+			Product(cid: $cid, docID: $docID) {
+				price
+				updatedAt
+			}
+		}`,
+		client.WithVariables(map[string]any{"cid": cid, "docID": []string{docID}}),
+	)
+	checkErrors("time-travel query", result)
+
+	data, _ := result.GQL.Data.(map[string]any)
+	products, _ := data["Product"].([]map[string]any)
+	if len(products) == 0 {
+		log.Fatalf("Commit %s not found for document %s.", cid, docID)
+	}
+	v := version{CID: cid, Price: products[0]["price"]}
+	switch t := products[0]["updatedAt"].(type) {
+	case time.Time:
+		v.UpdatedAt = t
+	case string:
+		v.UpdatedAt, _ = time.Parse(time.RFC3339, t)
+	}
+	return v
+}
+
+// resolveAt returns the CID of the commit designated by at, which is either a
+// CID of the document or an RFC 3339 time.
+func resolveAt(versions []version, at string) string {
+	for _, v := range versions {
+		if v.CID == at {
+			return at
+		}
+	}
+	if strings.HasPrefix(at, "bafy") {
+		log.Fatalf("Commit %s is not a commit of the product.", at)
+	}
+
+	t, err := time.Parse(time.RFC3339, at)
+	if err != nil {
+		log.Fatalf("-at must be a commit CID or an RFC 3339 time, got %q", at)
+	}
+	// The versions are ordered oldest first, so the last one that isn't newer
+	// than t is the one that was current at t.
+	cid := ""
+	for _, v := range versions {
+		if !v.UpdatedAt.After(t) {
+			cid = v.CID
+		}
+	}
+	if cid == "" {
+		log.Fatalf("The product didn't exist yet at %s.", at)
+	}
+	return cid
+}
+
+// checkErrors logs all GraphQL errors of a request result and exits if there
+// are any.
+func checkErrors(operation string, result *client.RequestResult) {
+	if len(result.GQL.Errors) == 0 {
+		return
+	}
+	for _, gqlErr := range result.GQL.Errors {
+		log.Printf("GraphQL error on %s: %v\n", operation, gqlErr)
+	}
+	log.Fatalf("Failed to run %s against DefraDB.", operation)
+}
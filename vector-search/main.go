@@ -0,0 +1,531 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sashabaranov/go-openai"                 // OpenAI client, compatible with Ollama's API
+	"github.com/sourcenetwork/defradb/client"           // DefraDB client
+	"github.com/sourcenetwork/defradb/node"             // DefraDB node
+	"github.com/sourcenetwork/examples/internal/shared" // Helpers shared by the examples
+)
+
+// This example demonstrates how to use DefraDB as a vector database for
+// semantic search, without involving a chat model.
+//
+// The process is as follows:
+// 1. Load a set of documents into DefraDB. DefraDB automatically creates
+//    vector embeddings for the document text using a local Ollama model.
+// 2. Convert the search query into a vector embedding with the same model.
+// 3. Use this query vector to rank the documents by cosine similarity and
+//    print the best matches with their scores.
+//
+// Usage:
+//
+//	go run . search [-top-k N] [-threshold T] [-data FILE] [-store DIR] [-print-query] [-json] "<query>"
+//	go run . sim "<query>" "<document>"
+//	go run . stats [-data FILE] [-store DIR]
+//
+// The `sim` subcommand doesn't use DefraDB. It embeds a query and a document
+// and prints their cosine similarity, which helps choosing a -threshold. The
+// `stats` subcommand loads the documents and reports how they are embedded and
+// indexed.
+//
+// Both `search` and `stats` load the documents into an in-memory DefraDB node,
+// which embeds every one of them. With -store, DefraDB persists them in the
+// given directory instead, and later runs reuse the stored documents and their
+// embeddings rather than loading them again.
+//
+// Prerequisites:
+// - An Ollama instance running locally. See: https://ollama.com/
+// - The 'nomic-embed-text' model pulled in Ollama: `ollama pull nomic-embed-text`
+
+const (
+	// We use a local embedding model running in Ollama. Ollama provides an
+	// OpenAI-compatible API endpoint.
+	ollamaBaseURL = "http://localhost:11434/v1"
+
+	// This model is specifically designed for generating high-quality embeddings.
+	// Model details: https://huggingface.co/nomic-ai/nomic-embed-text-v1.5
+	embeddingModel = "nomic-embed-text"
+
+	// The 'nomic-embed-text' model performs better when a specific prefix is
+	// added to differentiate between documents for storage and queries for
+	// retrieval. This is a model-specific requirement.
+	docPrefix   = "search_document: "
+	queryPrefix = "search_query: "
+
+	// maxLineSize is the maximum length of a line of the JSONL file.
+	maxLineSize = 1024 * 1024
+)
+
+// match is a single search result. The JSON tags give the shape of the
+// records printed with -json.
+type match struct {
+	Text       string  `json:"text"`
+	Category   string  `json:"category"`
+	Similarity float64 `json:"score"`
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	switch os.Args[1] {
+	case "search":
+		runSearch(os.Args[2:])
+	case "sim":
+		runSim(os.Args[2:])
+	case "stats":
+		runStats(os.Args[2:])
+	default:
+		usage()
+	}
+}
+
+// usage prints the available subcommands and exits.
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage:")
+	fmt.Fprintln(os.Stderr, `  vector-search search [-top-k N] [-threshold T] [-data FILE] [-store DIR] [-print-query] [-json] "<query>"`)
+	fmt.Fprintln(os.Stderr, `  vector-search sim "<query>" "<document>"`)
+	fmt.Fprintln(os.Stderr, `  vector-search stats [-data FILE] [-store DIR]`)
+	os.Exit(2)
+}
+
+// runSearch implements the `search` subcommand.
+func runSearch(args []string) {
+	fs := flag.NewFlagSet("search", flag.ExitOnError)
+	topK := fs.Int("top-k", 5, "maximum number of matches to return")
+	threshold := fs.Float64("threshold", 0.5, "minimum cosine similarity for a document to match")
+	dataPath := fs.String("data", "../rag/wiki.jsonl", "JSONL file with the documents to search")
+	storePath := fs.String("store", "", "directory to persist DefraDB data in (in-memory if empty)")
+	onParseError := fs.String("on-parse-error", "skip", "what to do with invalid lines of the data file: skip or abort")
+	printRequest := fs.Bool("print-query", false, "print the GraphQL similarity query and its variables to stderr")
+	jsonOutput := fs.Bool("json", false, "print the matches as JSON lines, one object per match")
+	fs.Parse(args)
+
+	query := strings.TrimSpace(strings.Join(fs.Args(), " "))
+	if query == "" {
+		log.Fatalf("Missing search query.")
+	}
+	if *topK <= 0 {
+		log.Fatalf("-top-k must be positive, got %d", *topK)
+	}
+	checkOnParseError(*onParseError)
+
+	ctx := context.Background()
+	db := setupDB(ctx, *storePath)
+	defer db.Close(ctx)
+	loadDocuments(ctx, db, *dataPath, *onParseError)
+
+	start := time.Now()
+	matches := search(ctx, db, query, *topK, *threshold, *printRequest)
+	log.Printf("Search (incl. query embedding) took %s\n", time.Since(start))
+
+	// With -json, the matches are printed as newline-delimited JSON, to be
+	// consumed by other programs, e.g. `jq`. No match prints nothing.
+	if *jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		for _, m := range matches {
+			err := enc.Encode(m)
+			if err != nil {
+				log.Fatalf("Failed to write match: %v", err)
+			}
+		}
+		log.Printf("%d documents matched the query.\n", len(matches))
+		return
+	}
+	if len(matches) == 0 {
+		fmt.Println("No documents matched the query.")
+		return
+	}
+	for i, m := range matches {
+		fmt.Printf("%d. [%.4f] (%s) %s\n", i+1, m.Similarity, m.Category, m.Text)
+	}
+}
+
+// runSim implements the `sim` subcommand.
+//
+// The texts are embedded exactly like in a search: the first one as a query
+// and the second one as a document, each with its prefix. Their similarity is
+// therefore the score the document would get when searching for the query.
+func runSim(args []string) {
+	fs := flag.NewFlagSet("sim", flag.ExitOnError)
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		log.Fatalf("sim takes exactly two texts, a query and a document, got %d.", fs.NArg())
+	}
+
+	ctx := context.Background()
+	openAIClient := openai.NewClientWithConfig(openai.ClientConfig{
+		BaseURL:    ollamaBaseURL,
+		HTTPClient: http.DefaultClient,
+	})
+	embeddingResp, err := openAIClient.CreateEmbeddings(ctx, openai.EmbeddingRequest{
+		Input: []string{queryPrefix + fs.Arg(0), docPrefix + fs.Arg(1)},
+		Model: embeddingModel,
+	})
+	if err != nil {
+		log.Fatalf("Failed to create embeddings: %v", err)
+	}
+	fmt.Printf("%.4f\n", shared.CosineSimilarity(embeddingResp.Data[0].Embedding, embeddingResp.Data[1].Embedding))
+}
+
+// runStats implements the `stats` subcommand.
+//
+// It reports the number of documents, how many of them have an embedding and
+// its dimension, and whether `text_v` is indexed. Search time grows with these
+// numbers: `_similarity` is computed for every document, unless an index lets
+// DefraDB skip some of them.
+func runStats(args []string) {
+	fs := flag.NewFlagSet("stats", flag.ExitOnError)
+	dataPath := fs.String("data", "../rag/wiki.jsonl", "JSONL file with the documents to load")
+	storePath := fs.String("store", "", "directory to persist DefraDB data in (in-memory if empty)")
+	onParseError := fs.String("on-parse-error", "skip", "what to do with invalid lines of the data file: skip or abort")
+	fs.Parse(args)
+	checkOnParseError(*onParseError)
+
+	ctx := context.Background()
+	db := setupDB(ctx, *storePath)
+	defer db.Close(ctx)
+	loadDocuments(ctx, db, *dataPath, *onParseError)
+
+	queryResult := db.DB.ExecRequest(ctx, `query {
+		Wiki {
+			text_v
+		}
+	}`)
+	if len(queryResult.GQL.Errors) > 0 {
+		for _, gqlErr := range queryResult.GQL.Errors {
+			log.Printf("GraphQL error on query: %v\n", gqlErr)
+		}
+		log.Fatalf("Failed to query documents from DefraDB.")
+	}
+	var resultData map[string][]struct {
+		TextV []float32 `json:"text_v"`
+	}
+	err := decodeData(queryResult.GQL.Data, &resultData)
+	if err != nil {
+		log.Fatalf("Unexpected query result from DefraDB: %v", err)
+	}
+	docs := resultData["Wiki"]
+	embedded := 0
+	dims := map[int]int{}
+	for _, doc := range docs {
+		if dim := len(doc.TextV); dim > 0 {
+			embedded++
+			dims[dim]++
+		}
+	}
+
+	fmt.Printf("Documents:           %d\n", len(docs))
+	fmt.Printf("Embedded documents:  %d\n", embedded)
+	for dim, count := range dims {
+		fmt.Printf("Embedding dimension: %d (%d documents)\n", dim, count)
+	}
+	if indexes := vectorIndexes(ctx, db); len(indexes) > 0 {
+		fmt.Printf("Index on text_v:     %s\n", strings.Join(indexes, ", "))
+	} else {
+		fmt.Println("Index on text_v:     none, every search compares the query with all documents")
+	}
+}
+
+// vectorIndexes returns the names of the indexes of the 'Wiki' collection that
+// cover `text_v`.
+func vectorIndexes(ctx context.Context, db *node.Node) []string {
+	col, err := db.DB.GetCollectionByName(ctx, "Wiki")
+	if err != nil {
+		log.Fatalf("Failed to get the 'Wiki' collection: %v", err)
+	}
+	indexes, err := col.GetIndexes(ctx)
+	if err != nil {
+		log.Fatalf("Failed to get the indexes of the 'Wiki' collection: %v", err)
+	}
+	var names []string
+	for _, index := range indexes {
+		for _, field := range index.Fields {
+			if field.Name == "text_v" {
+				names = append(names, index.Name)
+				break
+			}
+		}
+	}
+	return names
+}
+
+// setupDB starts a DefraDB node and adds the 'Wiki' collection.
+//
+// The node runs in memory, unless storePath is set, in which case DefraDB
+// persists its data with Badger in that directory. The collection is only
+// added if a previous run didn't already store it.
+//
+// The `@embedding` directive makes DefraDB generate the `text_v` vector from
+// the `text` field with the given provider and model whenever a document is
+// created or updated.
+func setupDB(ctx context.Context, storePath string) *node.Node {
+	log.Println("Setting up DefraDB...")
+	opts := []node.Option{node.WithDisableAPI(true), node.WithDisableP2P(true)}
+	if storePath != "" {
+		opts = append(opts, node.WithStorePath(storePath))
+	} else {
+		opts = append(opts, node.WithBadgerInMemory(true))
+	}
+	db, err := node.New(ctx, opts...)
+	if err != nil {
+		log.Fatalf("Failed to create DefraDB node: %v", err)
+	}
+	err = db.Start(ctx)
+	if err != nil {
+		log.Fatalf("Failed to start DefraDB node: %v", err)
+	}
+
+	_, err = db.DB.GetCollectionByName(ctx, "Wiki")
+	if err == nil {
+		log.Println("Collection 'Wiki' already exists in the store.")
+		return db
+	}
+	log.Println("Adding 'Wiki' collection schema to DefraDB...")
+	_, err = db.DB.AddSchema(ctx, fmt.Sprintf(`type Wiki {
+		text: String
+		category: String
+		text_v: [Float32!] @embedding(fields: ["text"], provider: "ollama", model: "%s")
+	}`, embeddingModel))
+	if err != nil {
+		log.Fatalf("Failed to add schema: %v", err)
+	}
+	return db
+}
+
+// checkOnParseError exits if the value of -on-parse-error isn't valid.
+func checkOnParseError(onParseError string) {
+	if onParseError != "skip" && onParseError != "abort" {
+		log.Fatalf("-on-parse-error must be skip or abort, got %q", onParseError)
+	}
+}
+
+// loadDocuments reads the JSONL file at path and creates one 'Wiki' document
+// per line.
+//
+// Embedding the documents is the slow part of a run, so if the collection
+// already holds documents, stored by an earlier run with -store, the file
+// isn't loaded again.
+//
+// A line that isn't valid JSON is reported with its line number and content,
+// and depending on onParseError, either skipped ("skip") or ends the program
+// ("abort"). The file is read line by line rather than with a json.Decoder,
+// which can't resume after a syntax error.
+func loadDocuments(ctx context.Context, db *node.Node, path string, onParseError string) {
+	if stored := countDocuments(ctx, db); stored > 0 {
+		log.Printf("Found %d documents in the store, skipping %s.\n", stored, path)
+		return
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		log.Fatalf("Failed to open %s. Make sure the file exists. Error: %v", path, err)
+	}
+	defer f.Close()
+
+	log.Printf("Reading JSON lines from %s and adding to the 'Wiki' collection...\n", path)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, maxLineSize)
+	count, invalid := 0, 0
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var article struct {
+			Text     string `json:"text"`
+			Category string `json:"category"`
+		}
+		err := json.Unmarshal(scanner.Bytes(), &article)
+		if err != nil {
+			if onParseError == "abort" {
+				log.Fatalf("Invalid JSON on line %d of %s: %v: %s", line, path, err, shared.Truncate(scanner.Text(), 100))
+			}
+			log.Printf("Warning: skipping line %d of %s: %v: %s\n", line, path, err, shared.Truncate(scanner.Text(), 100))
+			invalid++
+			continue
+		}
+
+		createResult := db.DB.ExecRequest(
+			ctx,
+			`mutation CreateWiki($input: [WikiMutationInputArg!]!) {
+				create_Wiki(input: $input) {
+					_docID
+				}
+			}`,
+			client.WithVariables(map[string]any{
+				"input": map[string]any{
+					"text":     docPrefix + article.Text,
+					"category": article.Category,
+				},
+			}),
+		)
+		if len(createResult.GQL.Errors) > 0 {
+			for _, gqlErr := range createResult.GQL.Errors {
+				log.Printf("GraphQL error on create: %v\n", gqlErr)
+			}
+			log.Fatalf("Failed to create document in DefraDB.")
+		}
+		count++
+	}
+	err = scanner.Err()
+	if err != nil {
+		log.Fatalf("Failed to read %s: %v", path, err)
+	}
+	if invalid > 0 {
+		log.Printf("Skipped %d invalid lines.\n", invalid)
+	}
+	log.Printf("Finished loading %d documents into DefraDB.\n", count)
+}
+
+// countDocuments returns the number of documents in the 'Wiki' collection.
+func countDocuments(ctx context.Context, db *node.Node) int {
+	queryResult := db.DB.ExecRequest(ctx, `query {
+		_count(Wiki: {})
+	}`)
+	if len(queryResult.GQL.Errors) > 0 {
+		for _, gqlErr := range queryResult.GQL.Errors {
+			log.Printf("GraphQL error on count: %v\n", gqlErr)
+		}
+		log.Fatalf("Failed to count documents in DefraDB.")
+	}
+	var resultData struct {
+		Count int `json:"_count"`
+	}
+	err := decodeData(queryResult.GQL.Data, &resultData)
+	if err != nil {
+		log.Fatalf("Unexpected query result from DefraDB: %v", err)
+	}
+	return resultData.Count
+}
+
+// search embeds the query and returns up to topK documents whose similarity to
+// it is above threshold, most similar first. With printRequest, the GraphQL
+// query sent to DefraDB is printed first (see printQuery).
+func search(ctx context.Context, db *node.Node, query string, topK int, threshold float64, printRequest bool) []match {
+	// DefraDB generates document embeddings itself, but the query embedding has
+	// to be created manually with the same model used in the schema.
+	openAIClient := openai.NewClientWithConfig(openai.ClientConfig{
+		BaseURL:    ollamaBaseURL,
+		HTTPClient: http.DefaultClient,
+	})
+	embeddingResp, err := openAIClient.CreateEmbeddings(ctx, openai.EmbeddingRequest{
+		Input: []string{queryPrefix + query},
+		Model: embeddingModel,
+	})
+	if err != nil {
+		log.Fatalf("Failed to create query embedding: %v", err)
+	}
+	queryVector := embeddingResp.Data[0].Embedding
+	checkDimension(ctx, db, len(queryVector))
+
+	// `_similarity` computes the cosine similarity between `text_v` and the
+	// query vector. We alias it to `sim` so we can filter and order by it.
+	request := fmt.Sprintf(`query Search($queryVector: [Float32!]!) {
+			Wiki(
+				filter: {_alias: {sim: {_gt: %g}}},
+				limit: %d,
+				order: {_alias: {sim: DESC}}
+			) {
+				text
+				category
+				sim: _similarity(text_v: {vector: $queryVector})
+			}
+		}`, threshold, topK)
+	variables := map[string]any{
+		"queryVector": queryVector,
+	}
+	if printRequest {
+		shared.PrintQuery(request, variables)
+	}
+	queryResult := db.DB.ExecRequest(ctx, request, client.WithVariables(variables))
+	if len(queryResult.GQL.Errors) > 0 {
+		for _, gqlErr := range queryResult.GQL.Errors {
+			log.Printf("GraphQL error on query: %v\n", gqlErr)
+		}
+		log.Fatalf("Failed to query documents from DefraDB.")
+	}
+
+	var resultData map[string][]struct {
+		Text     string  `json:"text"`
+		Category string  `json:"category"`
+		Sim      float64 `json:"sim"`
+	}
+	err = decodeData(queryResult.GQL.Data, &resultData)
+	if err != nil {
+		log.Fatalf("Unexpected query result from DefraDB: %v", err)
+	}
+	docs := resultData["Wiki"]
+	matches := make([]match, 0, len(docs))
+	for _, doc := range docs {
+		matches = append(matches, match{
+			Text:       strings.TrimPrefix(doc.Text, docPrefix),
+			Category:   doc.Category,
+			Similarity: doc.Sim,
+		})
+	}
+	return matches
+}
+
+// checkDimension exits if the stored document embeddings don't have the same
+// dimension as the query embedding.
+//
+// Vectors of different dimensions can't be compared, and depending on the
+// version DefraDB either fails or returns meaningless similarities. This
+// usually means the documents and the query were embedded with different
+// models, so we fail fast with an explanation instead.
+func checkDimension(ctx context.Context, db *node.Node, queryDim int) {
+	queryResult := db.DB.ExecRequest(ctx, `query {
+		Wiki(limit: 1) {
+			text_v
+		}
+	}`)
+	if len(queryResult.GQL.Errors) > 0 {
+		for _, gqlErr := range queryResult.GQL.Errors {
+			log.Printf("GraphQL error on query: %v\n", gqlErr)
+		}
+		log.Fatalf("Failed to query documents from DefraDB.")
+	}
+	var resultData map[string][]struct {
+		TextV []float32 `json:"text_v"`
+	}
+	err := decodeData(queryResult.GQL.Data, &resultData)
+	if err != nil {
+		log.Fatalf("Unexpected query result from DefraDB: %v", err)
+	}
+	docs := resultData["Wiki"]
+	if len(docs) == 0 {
+		return
+	}
+
+	docDim := len(docs[0].TextV)
+	log.Printf("Embedding dimension is %d.\n", queryDim)
+	if docDim != queryDim {
+		log.Fatalf("Embedding dimension mismatch: the documents have %d dimensions but the query has %d. "+
+			"Make sure they are embedded with the same model (%q).", docDim, queryDim, embeddingModel)
+	}
+}
+
+// decodeData decodes the data of a GraphQL result into v, which should be a
+// pointer to a struct or map matching the shape of the query.
+//
+// The data is made of generic maps and slices. Going through JSON converts it
+// into typed values, and reports an error if it doesn't fit.
+func decodeData(data any, v any) error {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}